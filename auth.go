@@ -0,0 +1,497 @@
+package gdown
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// OAuth2 and service-account authentication for private items
+//
+
+// OAuth2 endpoints. They can be pointed at a local server in tests. The
+// token endpoint of a credentials file (token_uri) is used unless TokenURL
+// has been changed from its default.
+var (
+	TokenURL      = defaultTokenURL
+	DeviceCodeURL = "https://oauth2.googleapis.com/device/code"
+)
+
+const defaultTokenURL = "https://oauth2.googleapis.com/token"
+
+// tokenURL returns the token endpoint for a credentials file that names
+// tokenURI, letting an explicitly set TokenURL win.
+func tokenURL(tokenURI string) string {
+	if tokenURI == "" || TokenURL != defaultTokenURL {
+		return TokenURL
+	}
+	return tokenURI
+}
+
+// DriveReadOnlyScope is the OAuth2 scope requested for downloads.
+const DriveReadOnlyScope = "https://www.googleapis.com/auth/drive.readonly"
+
+// Token is an OAuth2 access token.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// valid reports whether the token can be used for at least another minute.
+func (t *Token) valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Until(t.Expiry) > time.Minute)
+}
+
+// TokenSource returns access tokens for authorized requests. When
+// DownloadOptions.TokenSource is set, requests to Google hosts carry its
+// tokens and Drive items are accessed through the Drive API.
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+// clientTokenSource is implemented by the token sources of this package so
+// that token requests go through the same proxy and TLS settings as the
+// downloads they authorize.
+type clientTokenSource interface {
+	tokenWithClient(client *http.Client) (*Token, error)
+}
+
+// tokenResponse is the reply of the token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// postForm posts an OAuth2 request and decodes the JSON reply. OAuth2 error
+// replies are returned as the decoded response with a nil error so that the
+// device flow can inspect them.
+func postForm(client *http.Client, endpoint string, form url.Values) (*tokenResponse, error) {
+	resp, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("oauth2: couldn't parse response (%s): %w", resp.Status, err)
+	}
+	if tr.Error == "" && resp.StatusCode >= 300 {
+		tr.Error = resp.Status
+	}
+	return &tr, nil
+}
+
+func (tr *tokenResponse) token() *Token {
+	t := &Token{AccessToken: tr.AccessToken, TokenType: tr.TokenType, RefreshToken: tr.RefreshToken}
+	if tr.ExpiresIn > 0 {
+		t.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return t
+}
+
+func (tr *tokenResponse) err() error {
+	if tr.Description != "" {
+		return fmt.Errorf("oauth2: %s: %s", tr.Error, tr.Description)
+	}
+	return fmt.Errorf("oauth2: %s", tr.Error)
+}
+
+//
+// Service accounts (JWT bearer grant)
+//
+
+// serviceAccountKey is the JSON key file of a Google service account.
+type serviceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// serviceAccountSource exchanges signed JWTs for access tokens.
+type serviceAccountSource struct {
+	key      *serviceAccountKey
+	rsaKey   *rsa.PrivateKey
+	tokenURL string
+	scopes   []string
+	subject  string
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewServiceAccountTokenSource returns a TokenSource for a service account
+// JSON key. subject, if not empty, is the user impersonated through
+// domain-wide delegation. Scopes default to DriveReadOnlyScope.
+func NewServiceAccountTokenSource(keyJSON []byte, subject string, scopes ...string) (TokenSource, error) {
+	var key serviceAccountKey
+	if err := json.Unmarshal(keyJSON, &key); err != nil {
+		return nil, fmt.Errorf("couldn't parse service account key: %w", err)
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("not a service account key")
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("service account key: invalid private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("service account key: %w", err)
+		}
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("service account key: not an RSA private key")
+	}
+	if len(scopes) == 0 {
+		scopes = []string{DriveReadOnlyScope}
+	}
+	return &serviceAccountSource{key: &key, rsaKey: rsaKey, tokenURL: tokenURL(key.TokenURI), scopes: scopes, subject: subject}, nil
+}
+
+// Token returns the current access token, requesting a new one with a
+// freshly signed assertion when it is about to expire.
+func (s *serviceAccountSource) Token() (*Token, error) {
+	return s.tokenWithClient(http.DefaultClient)
+}
+
+func (s *serviceAccountSource) tokenWithClient(client *http.Client) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.valid() {
+		return s.token, nil
+	}
+	assertion, err := s.assertion(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	tr, err := postForm(client, s.tokenURL, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tr.Error != "" {
+		return nil, tr.err()
+	}
+	s.token = tr.token()
+	return s.token, nil
+}
+
+// assertion returns the signed JWT of the bearer grant (RFC 7523).
+func (s *serviceAccountSource) assertion(now time.Time) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if s.key.PrivateKeyID != "" {
+		header["kid"] = s.key.PrivateKeyID
+	}
+	claims := map[string]interface{}{
+		"iss":   s.key.ClientEmail,
+		"scope": strings.Join(s.scopes, " "),
+		"aud":   s.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	if s.subject != "" {
+		claims["sub"] = s.subject
+	}
+	var parts []string
+	for _, v := range []interface{}{header, claims} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(data))
+	}
+	signed := strings.Join(parts, ".")
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+//
+// User credentials (device authorization grant)
+//
+
+// oauthClient is an OAuth2 client ID as downloaded from the Google Cloud
+// console ("installed" or "web" application).
+type oauthClient struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// deviceSource authorizes a user with the device flow and keeps the token
+// in a cache file, refreshing it when it expires.
+type deviceSource struct {
+	client    oauthClient
+	tokenURL  string
+	cacheFile string
+	scopes    []string
+	quiet     bool
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewDeviceTokenSource returns a TokenSource for an OAuth2 client that
+// authorizes the user with the device flow (RFC 8628): the first time a
+// token is needed, a verification URL and code are printed to stderr.
+// Tokens are stored in cacheFile (if not empty) and refreshed as needed.
+func NewDeviceTokenSource(clientID, clientSecret, cacheFile string, quiet bool, scopes ...string) TokenSource {
+	if len(scopes) == 0 {
+		scopes = []string{DriveReadOnlyScope}
+	}
+	return &deviceSource{
+		client:    oauthClient{ClientID: clientID, ClientSecret: clientSecret},
+		tokenURL:  TokenURL,
+		cacheFile: cacheFile,
+		scopes:    scopes,
+		quiet:     quiet,
+	}
+}
+
+// Token returns a valid token from memory or the cache file, refreshing or
+// authorizing the user when needed.
+func (s *deviceSource) Token() (*Token, error) {
+	return s.tokenWithClient(http.DefaultClient)
+}
+
+func (s *deviceSource) tokenWithClient(client *http.Client) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == nil && s.cacheFile != "" {
+		if data, err := os.ReadFile(s.cacheFile); err == nil {
+			var t Token
+			if json.Unmarshal(data, &t) == nil {
+				s.token = &t
+			}
+		}
+	}
+	if s.token.valid() {
+		return s.token, nil
+	}
+	var err error
+	if s.token != nil && s.token.RefreshToken != "" {
+		var t *Token
+		if t, err = s.refresh(client, s.token.RefreshToken); err == nil {
+			return t, s.save(t)
+		}
+		if !s.quiet {
+			fmt.Fprintf(os.Stderr, "Couldn't refresh token, authorizing again: %v\n", err)
+		}
+	}
+	t, err := s.authorize(client)
+	if err != nil {
+		return nil, err
+	}
+	return t, s.save(t)
+}
+
+// refresh exchanges a refresh token for a new access token.
+func (s *deviceSource) refresh(client *http.Client, refreshToken string) (*Token, error) {
+	tr, err := postForm(client, s.tokenURL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {s.client.ClientID},
+		"client_secret": {s.client.ClientSecret},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tr.Error != "" {
+		return nil, tr.err()
+	}
+	t := tr.token()
+	if t.RefreshToken == "" {
+		t.RefreshToken = refreshToken
+	}
+	return t, nil
+}
+
+// authorize runs the device flow and waits for the user to approve it.
+func (s *deviceSource) authorize(client *http.Client) (*Token, error) {
+	resp, err := client.PostForm(DeviceCodeURL, url.Values{
+		"client_id": {s.client.ClientID},
+		"scope":     {strings.Join(s.scopes, " ")},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var dc struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		VerificationURI string `json:"verification_uri"`
+		ExpiresIn       int64  `json:"expires_in"`
+		Interval        int64  `json:"interval"`
+		Error           string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&dc); err != nil {
+		return nil, fmt.Errorf("oauth2: couldn't parse device code response (%s): %w", resp.Status, err)
+	}
+	if dc.Error != "" || dc.DeviceCode == "" {
+		return nil, fmt.Errorf("oauth2: device code request failed: %s %s", resp.Status, dc.Error)
+	}
+	verifyURL := dc.VerificationURL
+	if verifyURL == "" {
+		verifyURL = dc.VerificationURI
+	}
+	// The code has to be shown even in quiet mode, or the flow can't finish.
+	fmt.Fprintf(os.Stderr, "To authorize gdown, visit %s and enter the code %s\n", verifyURL, dc.UserCode)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+	for dc.ExpiresIn <= 0 || time.Now().Before(deadline) {
+		time.Sleep(interval)
+		tr, err := postForm(client, s.tokenURL, url.Values{
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code":   {dc.DeviceCode},
+			"client_id":     {s.client.ClientID},
+			"client_secret": {s.client.ClientSecret},
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch tr.Error {
+		case "":
+			return tr.token(), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, tr.err()
+		}
+	}
+	return nil, fmt.Errorf("oauth2: device code expired before authorization")
+}
+
+// save writes the token to the cache file, readable only by the user.
+func (s *deviceSource) save(t *Token) error {
+	s.token = t
+	if s.cacheFile == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.cacheFile), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	tmp := s.cacheFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.cacheFile)
+}
+
+// CredentialsFromFile returns a TokenSource for a credentials file: a
+// service account key, or an OAuth2 client ID ("installed" or "web"
+// application) authorized with the device flow. User tokens are cached
+// under the tokens directory of the cache in cacheDir (empty for the
+// default).
+func CredentialsFromFile(path, cacheDir string, quiet bool) (TokenSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Type      string       `json:"type"`
+		Installed *oauthClient `json:"installed"`
+		Web       *oauthClient `json:"web"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("couldn't parse credentials %s: %w", path, err)
+	}
+	if file.Type == "service_account" {
+		return NewServiceAccountTokenSource(data, "")
+	}
+	client := file.Installed
+	if client == nil {
+		client = file.Web
+	}
+	if client == nil || client.ClientID == "" {
+		return nil, errors.New("credentials must be a service account key or an OAuth2 client ID")
+	}
+	sum := sha256.Sum256([]byte(client.ClientID))
+	cacheFile := filepath.Join(getCacheRoot(cacheDir), "tokens", hex.EncodeToString(sum[:8])+".json")
+	src := NewDeviceTokenSource(client.ClientID, client.ClientSecret, cacheFile, quiet).(*deviceSource)
+	src.tokenURL = tokenURL(client.TokenURI)
+	return src, nil
+}
+
+// authTransport adds bearer tokens to requests for Google hosts. Other
+// hosts, such as mirrors, never see the token.
+type authTransport struct {
+	source TokenSource
+	base   http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isGoogleHost(req.URL) {
+		return t.base.RoundTrip(req)
+	}
+	var tok *Token
+	var err error
+	if cs, ok := t.source.(clientTokenSource); ok {
+		tok, err = cs.tokenWithClient(&http.Client{Transport: t.base})
+	} else {
+		tok, err = t.source.Token()
+	}
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	tokenType := tok.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+tok.AccessToken)
+	return t.base.RoundTrip(req)
+}
+
+// isGoogleHost reports whether u is a Drive host, the configured API host or
+// a Google API or content host.
+func isGoogleHost(u *url.URL) bool {
+	if isDriveHost(u) {
+		return true
+	}
+	if b, err := url.Parse(DriveAPIBaseURL); err == nil && b.Host == u.Host {
+		return true
+	}
+	host := u.Hostname()
+	return strings.HasSuffix(host, ".googleapis.com") || strings.HasSuffix(host, ".googleusercontent.com")
+}
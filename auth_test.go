@@ -0,0 +1,103 @@
+package gdown
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func serviceAccountJSON(t *testing.T, tokenURI string) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "test@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenURI,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestServiceAccountTokenURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"tok","expires_in":3600}`))
+	}))
+	defer srv.Close()
+	keyJSON := serviceAccountJSON(t, "https://oauth2.example.com/token")
+
+	src, err := NewServiceAccountTokenSource(keyJSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := src.(*serviceAccountSource).tokenURL; got != "https://oauth2.example.com/token" {
+		t.Errorf("token url = %s, want the key's token_uri", got)
+	}
+
+	defer func(u string) { TokenURL = u }(TokenURL)
+	TokenURL = srv.URL
+	src, err = NewServiceAccountTokenSource(keyJSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := src.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "tok" {
+		t.Errorf("access token = %q, want %q", tok.AccessToken, "tok")
+	}
+}
+
+func TestTokenRequestsUseProxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		switch r.URL.Host {
+		case "oauth2.example.com":
+			w.Write([]byte(`{"access_token":"tok","expires_in":3600}`))
+		default:
+			if r.Header.Get("Authorization") != "Bearer tok" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer proxy.Close()
+	src, err := NewServiceAccountTokenSource(serviceAccountJSON(t, "http://oauth2.example.com/token"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newHTTPClient(DownloadOptions{Proxy: proxy.URL, TokenSource: src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://www.googleapis.com/drive/v3/files/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %s", resp.Status)
+	}
+	if n := proxied.Load(); n != 2 {
+		t.Errorf("%d requests went through the proxy, want 2 (token and file)", n)
+	}
+}
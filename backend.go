@@ -0,0 +1,497 @@
+package gdown
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+//
+// Backends – how Drive items are listed and read
+//
+
+// Backend gives access to Drive items. The web scraper and the Drive API
+// implement it; a fixture or mock backend can be set in
+// DownloadOptions.Backend to download and list folders without Google.
+type Backend interface {
+	// Stat returns the metadata of an item, without its children.
+	Stat(id, resourceKey string) (*GoogleDriveFile, error)
+	// List returns the items directly inside a folder.
+	List(folder *GoogleDriveFile) ([]*GoogleDriveFile, error)
+	// Open returns the content of a binary file starting at offset. If cond
+	// is not nil and still matches the file, the content is empty and has
+	// NotModified set.
+	Open(file *GoogleDriveFile, offset int64, cond *Validators) (*Content, error)
+	// Export returns a Google-native item converted to format (e.g. docx).
+	Export(file *GoogleDriveFile, format string) (*Content, error)
+}
+
+// Content is the body of a file served by a Backend.
+type Content struct {
+	io.ReadCloser
+	Offset      int64       // position of the first byte; 0 if the backend ignored the requested offset
+	Header      http.Header // response header, if any
+	Source      string      // URL shown in logs
+	NotModified bool        // the file still matches the validators passed to Open
+}
+
+// NewScraperBackend returns the backend reading Drive's web pages, the
+// default when no API key or credentials are set.
+func NewScraperBackend(opts DownloadOptions) (Backend, error) {
+	client, err := newHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newScraperBackend(client, opts), nil
+}
+
+// NewAPIBackend returns the backend using the Drive v3 API, authenticated
+// with opts.APIKey or opts.TokenSource.
+func NewAPIBackend(opts DownloadOptions) (Backend, error) {
+	if !opts.useAPI() {
+		return nil, fmt.Errorf("the drive api needs an api key or credentials")
+	}
+	client, err := newHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &apiBackend{api: newDriveAPI(client, opts)}, nil
+}
+
+// useBackend reports whether Drive items are accessed through a Backend
+// rather than by following URLs.
+func (o DownloadOptions) useBackend() bool {
+	return o.Backend != nil || o.useAPI()
+}
+
+// newBackend returns the backend selected by opts.
+func newBackend(client *http.Client, opts DownloadOptions) Backend {
+	switch {
+	case opts.Backend != nil:
+		return opts.Backend
+	case opts.useAPI():
+		return &apiBackend{api: newDriveAPI(client, opts)}
+	default:
+		return newScraperBackend(client, opts)
+	}
+}
+
+// folderTree returns the tree of a folder, listing subfolders recursively.
+// Shortcuts are resolved to their targets unless opts.SkipShortcuts is set.
+func folderTree(b Backend, id, resourceKey string, opts FolderOptions) (*GoogleDriveFile, error) {
+	root, err := b.Stat(id, resourceKey)
+	if err != nil {
+		return nil, err
+	}
+	if root.IsShortcut() {
+		if root, err = resolveShortcut(b, root); err != nil {
+			return nil, err
+		}
+	}
+	if !root.IsFolder() {
+		return nil, fmt.Errorf("%s is not a folder", id)
+	}
+	return buildTree(b, root, opts, map[string]bool{})
+}
+
+// buildTree lists folder recursively. ancestors holds the IDs of the
+// folders being listed, to stop at shortcuts pointing back to them. Skipped
+// shortcuts stay in the tree unresolved, so that syncs can tell them from
+// deleted items; getDirectoryStructure leaves them out.
+func buildTree(b Backend, folder *GoogleDriveFile, opts FolderOptions, ancestors map[string]bool) (*GoogleDriveFile, error) {
+	ancestors[folder.ID] = true
+	defer delete(ancestors, folder.ID)
+	children, err := b.List(folder)
+	if err != nil {
+		return nil, err
+	}
+	gfile := *folder
+	gfile.Children = nil
+	for _, child := range children {
+		if child.IsShortcut() {
+			if opts.SkipShortcuts {
+				if !opts.Quiet {
+					fmt.Fprintf(os.Stderr, "Skipping shortcut %s %s\n", child.ID, child.Name)
+				}
+				gfile.Children = append(gfile.Children, child)
+				continue
+			}
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Resolving shortcut %s %s\n", child.ID, child.Name)
+			}
+			shortcut := child
+			if child, err = resolveShortcut(b, child); err != nil {
+				return nil, err
+			}
+			if ancestors[child.ID] {
+				if !opts.Quiet {
+					fmt.Fprintf(os.Stderr, "Skipping shortcut %s: it points to a folder containing it\n", child.Name)
+				}
+				gfile.Children = append(gfile.Children, shortcut)
+				continue
+			}
+		}
+		if !child.IsFolder() {
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Processing file %s %s\n", child.ID, child.Name)
+			}
+			gfile.Children = append(gfile.Children, child)
+			continue
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Retrieving folder %s %s\n", child.ID, child.Name)
+		}
+		sub, err := buildTree(b, child, opts, ancestors)
+		if err != nil {
+			return nil, err
+		}
+		gfile.Children = append(gfile.Children, sub)
+	}
+	return &gfile, nil
+}
+
+// resolveShortcut returns the item a shortcut points to, following chains
+// of shortcuts. The item keeps the shortcut's name, which is the one shown
+// in the folder.
+func resolveShortcut(b Backend, shortcut *GoogleDriveFile) (*GoogleDriveFile, error) {
+	seen := map[string]bool{}
+	cur := shortcut
+	for cur.IsShortcut() {
+		if cur.TargetID == "" {
+			// Listings don't always carry the target; stat'ing the
+			// shortcut itself may.
+			next, err := b.Stat(cur.ID, cur.ResourceKey)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't resolve shortcut %s: %w", shortcut.Name, err)
+			}
+			if next.IsShortcut() && next.TargetID == "" {
+				return nil, fmt.Errorf("couldn't resolve shortcut %s: unknown target", shortcut.Name)
+			}
+			if cur = next; !cur.IsShortcut() {
+				break
+			}
+		}
+		if seen[cur.ID] {
+			return nil, fmt.Errorf("couldn't resolve shortcut %s: shortcut cycle", shortcut.Name)
+		}
+		seen[cur.ID] = true
+		next, err := b.Stat(cur.TargetID, cur.TargetResourceKey)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't resolve shortcut %s: %w", shortcut.Name, err)
+		}
+		cur = next
+	}
+	target := *cur
+	target.Name = shortcut.Name
+	return &target, nil
+}
+
+// backendDownload downloads a Drive item through a backend: binary files
+// with Open and Google-native items with Export in opts.Format (or the
+// default format of their kind). An empty output or a directory takes the
+// item's name. cond revalidates binary files; exports are generated on the
+// fly and always downloaded.
+func backendDownload(b Backend, parsed *ParsedURL, output string, opts DownloadOptions, cond *Validators) (*downloadResult, error) {
+	file, err := b.Stat(parsed.ID, parsed.ResourceKey)
+	if err != nil {
+		return nil, err
+	}
+	if file.IsShortcut() {
+		if file, err = resolveShortcut(b, file); err != nil {
+			return nil, err
+		}
+	}
+	if file.IsFolder() {
+		return nil, fmt.Errorf("%s is a folder", parsed.ID)
+	}
+	name := sanitizeFilename(file.Name)
+	kind, native := nativeKinds[file.Type]
+	var format string
+	if native {
+		if format = opts.Format; format == "" {
+			format = defaultExportFormats[kind]
+		}
+		if format == "" {
+			return nil, fmt.Errorf("%s items can't be exported", kind)
+		}
+		if !strings.EqualFold(filepath.Ext(name), "."+format) {
+			name += "." + format
+		}
+	}
+	if output == "" {
+		output = name
+	} else if fi, err := os.Stat(output); err == nil && fi.IsDir() {
+		output = filepath.Join(output, name)
+	}
+
+	var content *Content
+	var startSize int64
+	if native {
+		// Exports are generated on the fly and can't be resumed.
+		opts.Resume = false
+		content, err = b.Export(file, format)
+	} else {
+		if opts.Resume {
+			if fi, err := os.Stat(output); err == nil {
+				startSize = fi.Size()
+			}
+		}
+		content, err = b.Open(file, startSize, cond)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	if content.NotModified {
+		return &downloadResult{Path: output, Header: content.Header, NotModified: true}, nil
+	}
+	if startSize > 0 && content.Offset != startSize {
+		// The backend sent the whole file; start over.
+		opts.Resume, startSize = false, 0
+	}
+	header := content.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	source := content.Source
+	if source == "" {
+		source = FileDownloadURL(file.ID, "")
+	}
+	resp := &http.Response{Body: content, Header: header, ContentLength: -1}
+	return saveResponse(resp, source, output, startSize, opts)
+}
+
+//
+// Scraper backend
+//
+
+// scraperBackend reads folder listings from Drive's web pages and file
+// contents from its download links.
+type scraperBackend struct {
+	client      *http.Client
+	opts        DownloadOptions
+	remainingOk bool // accept folders at the listing limit of MAX_NUMBER_FILES
+
+	mu     sync.Mutex
+	listed map[string][]*GoogleDriveFile // children parsed while stat'ing folders
+}
+
+func newScraperBackend(client *http.Client, opts DownloadOptions) *scraperBackend {
+	if opts.UserAgent == "" {
+		opts.UserAgent = "Mozilla/5.0 (compatible; gdown-go)"
+	}
+	return &scraperBackend{client: client, opts: opts, listed: map[string][]*GoogleDriveFile{}}
+}
+
+// page fetches a Drive web page in English and returns it with the final
+// URL, which differs from urlStr when Drive redirects (e.g. shortcuts).
+func (s *scraperBackend) page(urlStr, id, resourceKey string) (string, string, error) {
+	if strings.Contains(urlStr, "?") {
+		urlStr += "&hl=en"
+	} else {
+		urlStr += "?hl=en"
+	}
+	req, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", s.opts.UserAgent)
+	if resourceKey != "" {
+		req.Header.Set(resourceKeysHeader, id+"/"+resourceKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", "", fmt.Errorf("failed to retrieve %s, status: %s", urlStr, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	return string(body), resp.Request.URL.String(), nil
+}
+
+// folder fetches and parses a folder page.
+func (s *scraperBackend) folder(id, resourceKey string) (*GoogleDriveFile, []*GoogleDriveFile, error) {
+	body, finalURL, err := s.page(FolderURL(id, resourceKey), id, resourceKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	gfile, children, err := parseGoogleDriveFile(finalURL, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(children) == MAX_NUMBER_FILES && !s.remainingOk {
+		return nil, nil, fmt.Errorf("folder has more than %d files", MAX_NUMBER_FILES)
+	}
+	return gfile, children, nil
+}
+
+// titleSuffixes maps the suffixes of page titles to the type of the item.
+var titleSuffixes = map[string]string{
+	" - Google Drive":    "",
+	" - Google Docs":     "application/vnd.google-apps.document",
+	" - Google Sheets":   "application/vnd.google-apps.spreadsheet",
+	" - Google Slides":   "application/vnd.google-apps.presentation",
+	" - Google Drawings": "application/vnd.google-apps.drawing",
+}
+
+// Stat parses the folder page of id, or the view page if it isn't a folder.
+// The type of binary files isn't shown on the view page and is left empty.
+// Drive redirects shortcuts to their target, so they are returned resolved.
+func (s *scraperBackend) Stat(id, resourceKey string) (*GoogleDriveFile, error) {
+	gfile, children, folderErr := s.folder(id, resourceKey)
+	if folderErr == nil {
+		s.mu.Lock()
+		s.listed[gfile.ID] = children
+		s.mu.Unlock()
+		return gfile, nil
+	}
+	body, finalURL, err := s.page(withResourceKey(ViewURL(id, KindFile), resourceKey), id, resourceKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w (as a folder: %v)", err, folderErr)
+	}
+	if parsed, err := ClassifyUrl(finalURL); err == nil && parsed != nil && parsed.ID != "" {
+		// Shortcuts redirect to their target.
+		id, resourceKey = parsed.ID, parsed.ResourceKey
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	title := doc.Find("title").First().Text()
+	for suffix, typ := range titleSuffixes {
+		if name, ok := strings.CutSuffix(title, suffix); ok && name != "" {
+			return &GoogleDriveFile{ID: id, Name: name, Type: typ, ResourceKey: resourceKey}, nil
+		}
+	}
+	return nil, fmt.Errorf("file name cannot be extracted from title: %s", title)
+}
+
+func (s *scraperBackend) List(folder *GoogleDriveFile) ([]*GoogleDriveFile, error) {
+	s.mu.Lock()
+	children, ok := s.listed[folder.ID]
+	delete(s.listed, folder.ID)
+	s.mu.Unlock()
+	if ok {
+		return children, nil
+	}
+	_, children, err := s.folder(folder.ID, folder.ResourceKey)
+	return children, err
+}
+
+func (s *scraperBackend) Open(file *GoogleDriveFile, offset int64, cond *Validators) (*Content, error) {
+	return s.open(FileDownloadURL(file.ID, file.ResourceKey), file, offset, cond)
+}
+
+func (s *scraperBackend) Export(file *GoogleDriveFile, format string) (*Content, error) {
+	kind, ok := nativeKinds[file.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a Google-native item", file.ID)
+	}
+	urlStr, err := ExportURL(file.ID, kind, format)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(urlStr, file, 0, nil)
+}
+
+func (s *scraperBackend) open(urlStr string, file *GoogleDriveFile, offset int64, cond *Validators) (*Content, error) {
+	var resourceKey string
+	if file.ResourceKey != "" {
+		resourceKey = file.ID + "/" + file.ResourceKey
+	}
+	resp, urlStr, err := openURL(s.client, urlStr, resourceKey, offset, s.opts, cond)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, ErrFileURLRetrieval
+	}
+	c := &Content{ReadCloser: resp.Body, Header: resp.Header, Source: urlStr}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		c.Offset = offset
+	case http.StatusNotModified:
+		c.NotModified = true
+	}
+	return c, nil
+}
+
+//
+// API backend
+//
+
+// apiBackend accesses Drive items through the Drive v3 API.
+type apiBackend struct {
+	api *driveAPI
+}
+
+func (f *apiFile) driveFile() *GoogleDriveFile {
+	gfile := &GoogleDriveFile{
+		ID:                f.ID,
+		Name:              sanitizeFilename(f.Name),
+		Type:              f.MimeType,
+		ResourceKey:       f.ResourceKey,
+		DriveID:           f.DriveID,
+		TargetID:          f.ShortcutDetails.TargetID,
+		TargetResourceKey: f.ShortcutDetails.TargetResourceKey,
+		Size:              f.Size,
+		SizeVerified:      f.Size > 0,
+		MD5Checksum:       f.MD5Checksum,
+	}
+	if t, err := time.Parse(time.RFC3339, f.ModifiedTime); err == nil {
+		gfile.ModifiedTime = t
+	}
+	if len(f.Owners) > 0 {
+		if gfile.Owner = f.Owners[0].EmailAddress; gfile.Owner == "" {
+			gfile.Owner = f.Owners[0].DisplayName
+		}
+	}
+	return gfile
+}
+
+// Stat returns the metadata of an item; the root folder of a shared drive
+// is named after the drive.
+func (b *apiBackend) Stat(id, resourceKey string) (*GoogleDriveFile, error) {
+	meta, err := b.api.getFile(id, resourceKey)
+	if err != nil {
+		return nil, err
+	}
+	if meta.DriveID != "" && meta.ID == meta.DriveID {
+		if name, err := b.api.driveName(meta.DriveID); err == nil && name != "" {
+			meta.Name = name
+		}
+	}
+	return meta.driveFile(), nil
+}
+
+func (b *apiBackend) List(folder *GoogleDriveFile) ([]*GoogleDriveFile, error) {
+	files, err := b.api.listChildren(folder.ID, folder.ResourceKey, folder.DriveID)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]*GoogleDriveFile, len(files))
+	for i := range files {
+		children[i] = files[i].driveFile()
+	}
+	return children, nil
+}
+
+func (b *apiBackend) Open(file *GoogleDriveFile, offset int64, cond *Validators) (*Content, error) {
+	return b.api.media(file.ID, file.ResourceKey, offset, cond)
+}
+
+func (b *apiBackend) Export(file *GoogleDriveFile, format string) (*Content, error) {
+	return b.api.export(file.ID, file.ResourceKey, format)
+}
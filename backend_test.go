@@ -0,0 +1,215 @@
+package gdown_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/gdown"
+	"github.com/igolaizola/gdown/gdowntest"
+)
+
+// newServer starts an installed server holding a folder with a file, a
+// subfolder, a Google Docs document and a shortcut to a file outside it.
+func newServer(t *testing.T) *gdowntest.Server {
+	t.Helper()
+	srv := gdowntest.NewServer()
+	restore := srv.Install()
+	t.Cleanup(func() {
+		restore()
+		srv.Close()
+	})
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv.AddFolder("", "root", "root")
+	srv.AddFile("root", "a", "a.txt", []byte("alpha")).ModTime = modTime
+	srv.AddFolder("root", "sub", "sub")
+	srv.AddFile("sub", "b", "b.txt", []byte("bravo")).ModTime = modTime
+	srv.Add(&gdowntest.Item{ID: "doc", Name: "notes", MimeType: gdowntest.DocumentType, Parent: "root",
+		Exports: map[string][]byte{"docx": []byte("docx data"), "pdf": []byte("pdf data")}})
+	srv.AddFile("", "target", "target.txt", []byte("target")).ModTime = modTime
+	srv.AddShortcut("root", "link", "link.txt", "target")
+	return srv
+}
+
+// backends are the options selecting each way gdown reads Drive.
+var backends = map[string]gdown.DownloadOptions{
+	"scraper": {},
+	"api":     {APIKey: "test-key"},
+}
+
+func options(t *testing.T, base gdown.DownloadOptions) gdown.DownloadOptions {
+	base.Quiet, base.CacheDir = true, t.TempDir()
+	return base
+}
+
+func readFile(t *testing.T, p string) string {
+	t.Helper()
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCachedDownloadRevalidatesThroughBackends(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	defer srv.Install()()
+
+	scraper, err := gdown.NewScraperBackend(gdown.DownloadOptions{Quiet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, opts := range map[string]gdown.DownloadOptions{
+		"api":    {APIKey: "test-key"},
+		"custom": {Backend: scraper},
+	} {
+		t.Run(name, func(t *testing.T) {
+			file := srv.AddFile("", "file-"+name, "data.bin", []byte("first"))
+			urlStr := gdown.ViewURL(file.ID, gdown.KindFile)
+			output := filepath.Join(t.TempDir(), "data.bin")
+			opts.Quiet, opts.CacheDir = true, t.TempDir()
+			copts := gdown.CacheOptions{DownloadOptions: opts, Revalidate: true}
+
+			res, err := gdown.CachedDownloadWithOptions(urlStr, output, "", nil, copts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != gdown.CacheMiss {
+				t.Fatalf("first download: %s, want %s", res.Status, gdown.CacheMiss)
+			}
+			res, err = gdown.CachedDownloadWithOptions(urlStr, output, "", nil, copts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != gdown.CacheRevalidated {
+				t.Fatalf("unchanged file: %s, want %s", res.Status, gdown.CacheRevalidated)
+			}
+
+			file.Content = []byte("second")
+			res, err = gdown.CachedDownloadWithOptions(urlStr, output, "", nil, copts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != gdown.CacheRefetched {
+				t.Fatalf("changed file: %s, want %s", res.Status, gdown.CacheRefetched)
+			}
+			if data, _ := os.ReadFile(output); string(data) != "second" {
+				t.Errorf("output holds %q after the file changed", data)
+			}
+		})
+	}
+}
+
+func TestDownloadConfirmPage(t *testing.T) {
+	srv := newServer(t)
+	srv.Add(&gdowntest.Item{ID: "big", Name: "big.bin", Content: []byte("large file"), Confirm: true})
+	output := filepath.Join(t.TempDir(), "big.bin")
+	if _, err := gdown.Download(gdown.FileDownloadURL("big", ""), output, options(t, gdown.DownloadOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, output); got != "large file" {
+		t.Errorf("big.bin holds %q", got)
+	}
+	confirmed := false
+	for _, r := range srv.Requests() {
+		confirmed = confirmed || strings.Contains(r, "confirm=t")
+	}
+	if !confirmed {
+		t.Errorf("the virus scan warning wasn't confirmed: %q", srv.Requests())
+	}
+}
+
+func TestDownloadQuotaExceeded(t *testing.T) {
+	srv := newServer(t)
+	srv.Add(&gdowntest.Item{ID: "popular", Name: "popular.bin", Content: []byte("data"), QuotaExceeded: true})
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "popular.bin")
+			if _, err := gdown.Download(gdown.FileDownloadURL("popular", ""), output, options(t, base)); err == nil {
+				t.Error("download of a file over quota succeeded")
+			}
+		})
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			srv := newServer(t)
+			var mu sync.Mutex
+			var ranges []string
+			handler := srv.Config.Handler
+			srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if v := r.Header.Get("Range"); v != "" {
+					mu.Lock()
+					ranges = append(ranges, v)
+					mu.Unlock()
+				}
+				handler.ServeHTTP(w, r)
+			})
+			output := filepath.Join(t.TempDir(), "a.txt")
+			if err := os.WriteFile(output, []byte("al"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			opts := options(t, base)
+			opts.Resume = true
+			if _, err := gdown.Download(gdown.FileDownloadURL("a", ""), output, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, output); got != "alpha" {
+				t.Errorf("a.txt holds %q after resuming", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ranges) != 1 || ranges[0] != "bytes=2-" {
+				t.Errorf("range requests: %q, want one for bytes=2-", ranges)
+			}
+		})
+	}
+}
+
+func TestDownloadExport(t *testing.T) {
+	newServer(t)
+	urls := map[string]string{
+		"scraper": "",
+		"api":     gdown.ViewURL("doc", gdown.KindDocument),
+	}
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			urlStr := urls[name]
+			if urlStr == "" {
+				var err error
+				if urlStr, err = gdown.ExportURL("doc", gdown.KindDocument, "pdf"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts := options(t, base)
+			opts.Format = "pdf"
+			output := filepath.Join(t.TempDir(), "notes.pdf")
+			if _, err := gdown.Download(urlStr, output, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, output); got != "pdf data" {
+				t.Errorf("notes.pdf holds %q", got)
+			}
+		})
+	}
+}
+
+// The scraper resolves shortcuts while listing folders (see
+// TestDownloadFolder); links to a shortcut itself need the API.
+func TestDownloadShortcut(t *testing.T) {
+	newServer(t)
+	output := filepath.Join(t.TempDir(), "link.txt")
+	if _, err := gdown.Download(gdown.FileDownloadURL("link", ""), output, options(t, backends["api"])); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, output); got != "target" {
+		t.Errorf("link.txt holds %q, want the target's content", got)
+	}
+}
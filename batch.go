@@ -0,0 +1,311 @@
+package gdown
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//
+// Batch downloads from an input list
+//
+
+// BatchItem is one download of a batch list.
+type BatchItem struct {
+	URL     string   `json:"url"`
+	Output  string   `json:"output,omitempty"`  // file or directory; empty uses the name sent by the server
+	Hash    string   `json:"hash,omitempty"`    // expected hash in the format <algo>:<hash_value>
+	Format  string   `json:"format,omitempty"`  // export format of Google Docs/Sheets/Slides
+	Mirrors []string `json:"mirrors,omitempty"` // fallback URLs tried in order when URL fails
+	Size    int64    `json:"size,omitempty"`    // expected size, used for preallocation
+}
+
+// ParseBatchList reads a batch list. Each non-empty line that doesn't start
+// with '#' is either a JSON object with the fields of BatchItem,
+// or up to four columns "URL [OUTPUT [HASH [FORMAT]]]" separated by tabs
+// (or, without tabs, by whitespace). "-" leaves a column empty.
+func ParseBatchList(r io.Reader) ([]BatchItem, error) {
+	var items []BatchItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var item BatchItem
+		if strings.HasPrefix(line, "{") {
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		} else {
+			var cols []string
+			if strings.Contains(line, "\t") {
+				cols = strings.Split(line, "\t")
+			} else {
+				cols = strings.Fields(line)
+			}
+			if len(cols) > 4 {
+				return nil, fmt.Errorf("line %d: too many columns", lineNo)
+			}
+			fields := []*string{&item.URL, &item.Output, &item.Hash, &item.Format}
+			for i, col := range cols {
+				if col = strings.TrimSpace(col); col != "-" {
+					*fields[i] = col
+				}
+			}
+		}
+		if item.URL == "" {
+			return nil, fmt.Errorf("line %d: missing url", lineNo)
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// BatchStatus is the outcome of one batch item.
+type BatchStatus string
+
+const (
+	BatchDownloaded BatchStatus = "downloaded"
+	BatchSkipped    BatchStatus = "skipped" // the output already exists (and matches the hash)
+	BatchFailed     BatchStatus = "failed"
+)
+
+// BatchResult is the outcome of downloading one BatchItem.
+type BatchResult struct {
+	Item   BatchItem
+	Path   string
+	Status BatchStatus
+	Source string // the URL or mirror that served a download
+	Err    error
+}
+
+// BatchOptions holds settings for DownloadBatch.
+type BatchOptions struct {
+	DownloadOptions
+	Concurrency int    // parallel downloads; 0 or 1 means sequential
+	Dir         string // base directory for relative outputs and items without one
+	Overwrite   bool   // download even if the output already exists
+	RaceMirrors bool   // download items with mirrors from every source at once
+}
+
+// DownloadBatch downloads every item with a shared HTTP client and up to
+// opts.Concurrency downloads in parallel. Existing outputs are skipped
+// unless they don't match the item's hash or opts.Overwrite is set.
+// Results are returned in the order of items.
+func DownloadBatch(items []BatchItem, opts BatchOptions) ([]BatchResult, error) {
+	client, err := newHTTPClient(opts.DownloadOptions)
+	if err != nil {
+		return nil, err
+	}
+	dir := opts.Dir
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]BatchResult, len(items))
+	names := &batchNames{owner: map[string]string{}}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item BatchItem) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = downloadBatchItem(client, item, dir, names, opts)
+		}(i, item)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// batchNames tracks the files a batch saves under the name sent by the
+// server, so that two items with the same name don't overwrite each other.
+type batchNames struct {
+	mu    sync.Mutex
+	owner map[string]string // path -> item URL
+}
+
+// claim reserves p for the item downloaded from urlStr.
+func (n *batchNames) claim(p, urlStr string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if other, ok := n.owner[p]; ok && other != urlStr {
+		return fmt.Errorf("%s is also the file name of %s; give one of them an output path", p, other)
+	}
+	n.owner[p] = urlStr
+	return nil
+}
+
+// completedBatchItem returns the file a previous run saved item to in dir
+// under the name sent by the server, as recorded by recordFile, or "" if
+// there is none.
+func completedBatchItem(item BatchItem, dir string, opts DownloadOptions) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	entries, _ := lookupCacheRef(localRecordsRoot(getCacheRoot(opts.CacheDir)), urlRef(item.URL))
+	for _, entry := range entries {
+		if filepath.Dir(entry.Path) != dir {
+			continue
+		}
+		if item.Hash != "" {
+			if ok, _ := assertFileHash(entry.Path, item.Hash, true); !ok {
+				continue
+			}
+		}
+		return entry.Path
+	}
+	return ""
+}
+
+// downloadBatchItem downloads a single item of a batch.
+func downloadBatchItem(client *http.Client, item BatchItem, dir string, names *batchNames, opts BatchOptions) BatchResult {
+	res := BatchResult{Item: item}
+	output := item.Output
+	// A directory makes Download use the file name sent by the server.
+	isDir := output == "" || strings.HasSuffix(output, "/") || strings.HasSuffix(output, string(os.PathSeparator))
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(dir, output)
+	}
+	mkdir := filepath.Dir(output)
+	if isDir {
+		mkdir = output
+	}
+	if err := os.MkdirAll(mkdir, os.ModePerm); err != nil {
+		res.Status, res.Err = BatchFailed, err
+		return res
+	}
+
+	if !opts.Overwrite {
+		// Outputs named by the server are only known from earlier runs.
+		if isDir {
+			if p := completedBatchItem(item, output, opts.DownloadOptions); p != "" {
+				res.Path, res.Status = p, BatchSkipped
+				return res
+			}
+		} else if fileExists(output) {
+			if item.Hash == "" {
+				res.Path, res.Status = output, BatchSkipped
+				return res
+			}
+			if ok, _ := assertFileHash(output, item.Hash, true); ok {
+				res.Path, res.Status = output, BatchSkipped
+				return res
+			}
+		}
+	}
+
+	dopts := opts.DownloadOptions
+	if item.Format != "" {
+		dopts.Format = item.Format
+	}
+	dopts.ExpectedSize = item.Size
+	var sources []string
+	for _, src := range append([]string{item.URL}, item.Mirrors...) {
+		src, err := batchSourceURL(src, dopts)
+		if err != nil {
+			res.Status, res.Err = BatchFailed, err
+			return res
+		}
+		sources = append(sources, src)
+	}
+	var err error
+	switch {
+	case isOffline(dopts):
+		res.Path, res.Source, err = offlineSources(sources, output, item.Hash, dopts)
+	case isDir:
+		res.Path, res.Source, err = downloadBatchToDir(client, item, sources, output, names, dopts, opts.RaceMirrors)
+	default:
+		var dl *downloadResult
+		dl, res.Source, err = downloadSources(client, sources, output, item.Hash, dopts, nil, opts.RaceMirrors)
+		if dl != nil {
+			res.Path = dl.Path
+		}
+	}
+	if err != nil {
+		res.Status, res.Err = BatchFailed, err
+		return res
+	}
+	res.Status = BatchDownloaded
+	return res
+}
+
+// batchSourceURL returns the URL downloaded for a batch source. Without the
+// Drive API, editor links of Google-native items are replaced by their
+// export in opts.Format and, with opts.Fuzzy, other links to a file by its
+// direct download link.
+func batchSourceURL(src string, opts DownloadOptions) (string, error) {
+	parsed, err := ClassifyUrl(src)
+	if err != nil || parsed == nil || parsed.ID == "" || opts.useBackend() {
+		return src, nil
+	}
+	switch parsed.Kind {
+	case KindDocument, KindSpreadsheet, KindPresentation, KindDrawing:
+		if u, err := url.Parse(src); err == nil && strings.Contains(u.Path, "/export") {
+			return src, nil
+		}
+		exportURL, err := ExportURL(parsed.ID, parsed.Kind, opts.Format)
+		if err != nil {
+			return "", err
+		}
+		return withResourceKey(exportURL, parsed.ResourceKey), nil
+	case KindFile:
+		if opts.Fuzzy && !parsed.IsDownloadLink {
+			return FileDownloadURL(parsed.ID, parsed.ResourceKey), nil
+		}
+	}
+	return src, nil
+}
+
+// downloadBatchToDir downloads an item into dir under the name sent by the
+// server. The file is downloaded into a private temporary directory and
+// only moved into place once its name is claimed, and the result is
+// recorded in the cache index so that later runs can skip it.
+func downloadBatchToDir(client *http.Client, item BatchItem, sources []string, dir string, names *batchNames, opts DownloadOptions, race bool) (string, string, error) {
+	tmpDir, err := os.MkdirTemp(dir, ".gdown-batch")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	// A fresh temporary directory has nothing to resume.
+	opts.Resume = false
+	dl, source, err := downloadSources(client, sources, tmpDir, item.Hash, opts, nil, race)
+	if err != nil {
+		return "", "", err
+	}
+	final := filepath.Join(dir, filepath.Base(dl.Path))
+	if err := names.claim(final, item.URL); err != nil {
+		return "", source, err
+	}
+	if err := os.Rename(dl.Path, final); err != nil {
+		return "", source, err
+	}
+	var hashes map[string]string
+	if algo, sum, err := splitHash(item.Hash); err == nil {
+		hashes = map[string]string{algo: sum}
+	}
+	_ = recordFile(opts, item.URL, final, hashes)
+	return final, source, nil
+}
@@ -0,0 +1,106 @@
+package gdown_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/igolaizola/gdown"
+	"github.com/igolaizola/gdown/gdowntest"
+)
+
+func TestDownloadBatchServerNames(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		name := r.URL.Query().Get("name")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		fmt.Fprintf(w, "content of %s", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	items := []gdown.BatchItem{
+		{URL: srv.URL + "/a?name=a.txt"},
+		{URL: srv.URL + "/b?name=b.txt"},
+		{URL: srv.URL + "/c?name=a.txt"}, // same name as the first item
+	}
+	opts := gdown.BatchOptions{
+		DownloadOptions: gdown.DownloadOptions{Quiet: true, CacheDir: t.TempDir()},
+		Concurrency:     1,
+		Dir:             dir,
+	}
+	results, err := gdown.DownloadBatch(items, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []gdown.BatchStatus{gdown.BatchDownloaded, gdown.BatchDownloaded, gdown.BatchFailed} {
+		if results[i].Status != want {
+			t.Errorf("%s: %s, want %s (%v)", items[i].URL, results[i].Status, want, results[i].Err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "content of /a" {
+		t.Fatalf("a.txt holds %q", got)
+	}
+
+	// Items saved under the server's name are skipped on the next run.
+	requests.Store(0)
+	results, err = gdown.DownloadBatch(items[:2], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if res.Status != gdown.BatchSkipped {
+			t.Errorf("%s: %s, want skipped", res.Item.URL, res.Status)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("%d requests on a run with nothing to download", n)
+	}
+}
+
+func TestDownloadBatchExportAndFuzzy(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	defer srv.Install()()
+	srv.AddFile("", "a", "a.txt", []byte("alpha"))
+	srv.Add(&gdowntest.Item{ID: "doc", Name: "notes", MimeType: gdowntest.DocumentType,
+		Exports: map[string][]byte{"docx": []byte("docx data"), "pdf": []byte("pdf data")}})
+
+	dir := t.TempDir()
+	items := []gdown.BatchItem{
+		{URL: gdown.ViewURL("doc", gdown.KindDocument), Output: "notes.pdf", Format: "pdf"},
+		{URL: gdown.ViewURL("doc", gdown.KindDocument), Output: "notes.docx"},
+		{URL: gdown.ViewURL("a", gdown.KindFile), Output: "a.txt"},
+	}
+	opts := gdown.BatchOptions{
+		DownloadOptions: gdown.DownloadOptions{Quiet: true, Fuzzy: true, CacheDir: t.TempDir()},
+		Dir:             dir,
+	}
+	results, err := gdown.DownloadBatch(items, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"pdf data", "docx data", "alpha"} {
+		if res := results[i]; res.Status != gdown.BatchDownloaded {
+			t.Errorf("%s: %s %v", res.Item.Output, res.Status, res.Err)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, items[i].Output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s holds %q, want %q", items[i].Output, data, want)
+		}
+	}
+}
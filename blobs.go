@@ -0,0 +1,240 @@
+package gdown
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//
+// Content-addressable blob store – cached files keyed by sha256
+//
+// Files downloaded into the cache directory are hard-linked into
+// blobs/sha256/<digest>, and the URL-named cache files act as aliases of
+// those blobs. The same content reached through different URLs is therefore
+// stored once, and a request with a known hash can be served from the cache
+// without any network access regardless of its URL.
+//
+
+// blobPath returns where content with the given sha256 digest is stored.
+func blobPath(cacheRoot, sum string) string {
+	return filepath.Join(cacheRoot, "blobs", "sha256", sum)
+}
+
+// storeBlob makes the cached file p share storage with the blob for sum,
+// creating the blob from p if it doesn't exist yet. A blob whose content no
+// longer matches sum is replaced by p, and p is left alone if its mode
+// differs from the blob's (e.g. after chmod +x) so that the change doesn't
+// spread to every alias.
+func storeBlob(cacheRoot, p, sum string) error {
+	if sum == "" {
+		return errors.New("missing sha256 digest")
+	}
+	bp := blobPath(cacheRoot, sum)
+	if err := os.MkdirAll(filepath.Dir(bp), os.ModePerm); err != nil {
+		return err
+	}
+	blobInfo, err := os.Stat(bp)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.Link(p, bp)
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(info, blobInfo) || info.Mode() != blobInfo.Mode() {
+		return nil
+	}
+	if actual, err := fileSum(bp, "sha256"); err != nil || actual != sum {
+		return linkOrCopy(p, bp)
+	}
+	return linkOrCopy(bp, p)
+}
+
+// unshareBlob gives the cached file p its own copy of the data if it is an
+// alias of the blob for sum, so that it can be modified in place without
+// changing the blob and its other aliases.
+func unshareBlob(cacheRoot, p, sum string) error {
+	if sum == "" {
+		return nil
+	}
+	blobInfo, err := os.Stat(blobPath(cacheRoot, sum))
+	if err != nil {
+		return nil
+	}
+	info, err := os.Stat(p)
+	if err != nil || !os.SameFile(info, blobInfo) {
+		return err
+	}
+	if err := copyFile(p, p); err != nil {
+		return err
+	}
+	return os.Chmod(p, info.Mode())
+}
+
+// findBlob returns a cached file in one of dirs whose content is expected to
+// match hash. sha256 hashes are looked up in the blob store first; every
+// algorithm is then looked up in the secondary index of the cache.
+func findBlob(dirs []string, hash string) (string, error) {
+	algo, sum, err := splitHash(hash)
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range dirs {
+		if algo == "sha256" {
+			if bp := blobPath(dir, sum); fileExists(bp) {
+				return bp, nil
+			}
+		}
+		entries, ok := lookupCacheRef(dir, path.Join(algo, sum))
+		if !ok {
+			if entries, err = ListCache(dir); err != nil {
+				return "", err
+			}
+		}
+		for _, entry := range entries {
+			if entry.Hashes[algo] == sum {
+				return entry.Path, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// linkOrCopy hard-links src to dst, falling back to a copy when linking
+// isn't possible (e.g. across file systems).
+func linkOrCopy(src, dst string) error {
+	tmp := dst + ".gdown-link"
+	_ = os.Remove(tmp)
+	if err := os.Link(src, tmp); err == nil {
+		return os.Rename(tmp, dst)
+	}
+	return copyFile(src, dst)
+}
+
+// blobCacheHit serves urlStr from any cached file matching hash. Files in
+// the default cache location become aliases of the blob; explicit output
+// paths receive a copy so that later edits can't corrupt the cache. It
+// returns nil if no cached content matches.
+func blobCacheHit(cacheRoot, urlStr, outputPath, hash string, defaultOutput bool, opts CacheOptions) (*CacheResult, error) {
+	dirs := append([]string{cacheRoot}, getReadOnlyCacheDirs(opts.ReadOnlyDirs)...)
+	src, err := findBlob(dirs, hash)
+	if err != nil || src == "" {
+		return nil, err
+	}
+	if ok, _ := assertFileHash(src, hash, true); !ok {
+		return nil, nil
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Found content with hash %s in cache: %s\n", hash, src)
+	}
+	if defaultOutput && isWithin(cacheRoot, src) {
+		err = linkOrCopy(src, outputPath)
+	} else {
+		err = copyFile(src, outputPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry, err := newCacheEntry(urlStr, outputPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCacheEntry(cacheRoot, entry); err != nil {
+		return nil, err
+	}
+	if defaultOutput {
+		_ = storeBlob(cacheRoot, outputPath, entry.Hashes["sha256"])
+	}
+	return &CacheResult{Path: outputPath, Status: CacheHit, Entry: entry}, nil
+}
+
+// isWithin reports whether p is located inside dir.
+func isWithin(dir, p string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	p, err = filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(p, dir+string(os.PathSeparator))
+}
+
+// DedupCache moves every file stored in the cache directory dir (empty for
+// the default) into the blob store, hard-linking files with identical
+// content to a single blob. It returns the number of bytes reclaimed.
+func DedupCache(dir string) (int64, error) {
+	cacheRoot := getCacheRoot(dir)
+	entries, err := ListCache(dir)
+	if err != nil {
+		return 0, err
+	}
+	var saved int64
+	for _, entry := range entries {
+		if !isWithin(cacheRoot, entry.Path) {
+			continue
+		}
+		if entry.Hashes["sha256"] == "" {
+			indexed, err := newCacheEntry(entry.URL, entry.Path, nil)
+			if err != nil {
+				return saved, err
+			}
+			indexed.Name, indexed.FetchedAt, indexed.CheckedAt = entry.Name, entry.FetchedAt, entry.CheckedAt
+			if err := writeCacheEntry(cacheRoot, indexed); err != nil {
+				return saved, err
+			}
+			entry = indexed
+		}
+		before, err := os.Stat(entry.Path)
+		if err != nil {
+			return saved, err
+		}
+		if err := storeBlob(cacheRoot, entry.Path, entry.Hashes["sha256"]); err != nil {
+			return saved, err
+		}
+		// The file only shares storage now if it was replaced by a link.
+		if after, err := os.Stat(entry.Path); err == nil && !os.SameFile(before, after) {
+			saved += entry.Size
+		}
+	}
+	return saved, nil
+}
+
+// pruneBlobs removes blobs no longer referenced by any entry stored in the
+// cache directory. Entries elsewhere hold copies, never links to blobs.
+func pruneBlobs(cacheRoot string, entries []*CacheEntry) error {
+	referenced := map[string]bool{}
+	for _, entry := range entries {
+		if !isWithin(cacheRoot, entry.Path) {
+			continue
+		}
+		if sum := entry.Hashes["sha256"]; sum != "" {
+			referenced[sum] = true
+		}
+	}
+	blobDir := filepath.Join(cacheRoot, "blobs", "sha256")
+	blobs, err := os.ReadDir(blobDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		if b.IsDir() || referenced[b.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(blobDir, b.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
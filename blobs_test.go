@@ -0,0 +1,119 @@
+package gdown
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// cacheTwoAliases caches the same content under two URLs and returns their
+// results.
+func cacheTwoAliases(t *testing.T, opts CacheOptions) (*CacheResult, *CacheResult, *httptest.Server) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if r.URL.Path == "/other" {
+			w.Write([]byte("0123456789"))
+			return
+		}
+		w.Write([]byte("abcdefghij"))
+	}))
+	t.Cleanup(srv.Close)
+	a, err := CachedDownloadWithOptions(srv.URL+"/a", "", "", nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := CachedDownloadWithOptions(srv.URL+"/b", "", "", nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b, srv
+}
+
+func TestInPlacePostprocessorDoesNotChangeBlob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no exec bit on windows")
+	}
+	opts := CacheOptions{DownloadOptions: DownloadOptions{Quiet: true, CacheDir: t.TempDir()}}
+	a, b, srv := cacheTwoAliases(t, opts)
+	if _, err := CachedDownloadWithOptions(srv.URL+"/a", "", "", ChmodExecPostprocessor(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for p, exec := range map[string]bool{
+		a.Path: true,
+		b.Path: false,
+		blobPath(getCacheRoot(opts.CacheDir), a.Entry.Hashes["sha256"]): false,
+	} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode()&0111 != 0; got != exec {
+			t.Errorf("%s: executable %v, want %v", p, got, exec)
+		}
+	}
+
+	// Deduplicating must not link the executable copy back to the blob.
+	if _, err := DedupCache(opts.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(b.Path); info.Mode()&0111 != 0 {
+		t.Errorf("%s became executable after dedup", b.Path)
+	}
+}
+
+func TestGCCountsSharedBlobOnce(t *testing.T) {
+	opts := CacheOptions{DownloadOptions: DownloadOptions{Quiet: true, CacheDir: t.TempDir()}}
+	_, _, srv := cacheTwoAliases(t, opts)
+	time.Sleep(10 * time.Millisecond)
+	if _, err := CachedDownloadWithOptions(srv.URL+"/other", "", "", nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two aliases of one blob plus another file take 20 bytes.
+	evicted, freed, err := GCCache(opts.CacheDir, CacheGCOptions{MaxSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 0 || freed != 0 {
+		t.Fatalf("evicted %d entries (%d bytes) within budget", len(evicted), freed)
+	}
+
+	// Over budget, both aliases of the least recently used blob go.
+	evicted, freed, err = GCCache(opts.CacheDir, CacheGCOptions{MaxSize: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 2 || freed != 10 {
+		t.Fatalf("evicted %d entries (%d bytes), want 2 entries (10 bytes)", len(evicted), freed)
+	}
+	entries, err := ListCache(opts.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].URL != srv.URL+"/other" {
+		t.Fatalf("remaining entries: %v", entries)
+	}
+}
+
+func TestFindBlobByIndexedHash(t *testing.T) {
+	opts := CacheOptions{DownloadOptions: DownloadOptions{Quiet: true, CacheDir: t.TempDir()}}
+	a, _, _ := cacheTwoAliases(t, opts)
+	root := getCacheRoot(opts.CacheDir)
+	p, err := findBlob([]string{root}, "md5:"+a.Entry.Hashes["md5"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == "" {
+		t.Fatal("content not found by md5")
+	}
+	if err := removeCacheEntry(root, a.Entry); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := findBlob([]string{root}, "md5:"+a.Entry.Hashes["md5"]); p == a.Entry.Path {
+		t.Fatalf("removed entry still found: %s", p)
+	}
+}
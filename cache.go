@@ -0,0 +1,917 @@
+package gdown
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// Cache index – metadata stored alongside cached files
+//
+
+// CacheStatus reports how a cached download was satisfied.
+type CacheStatus string
+
+const (
+	// CacheHit means the cached file was used without contacting the server.
+	CacheHit CacheStatus = "hit"
+	// CacheRevalidated means the server confirmed the cached file is current.
+	CacheRevalidated CacheStatus = "revalidated"
+	// CacheRefetched means an existing cached file was replaced by a new download.
+	CacheRefetched CacheStatus = "refetched"
+	// CacheMiss means there was no cached file and it was downloaded.
+	CacheMiss CacheStatus = "miss"
+)
+
+// CacheEntry holds the metadata recorded for a cached file.
+type CacheEntry struct {
+	URL           string            `json:"url"`
+	Path          string            `json:"path"`
+	Name          string            `json:"name,omitempty"` // file name sent by the server or given by the folder
+	Size          int64             `json:"size"`
+	Hashes        map[string]string `json:"hashes,omitempty"` // algorithm -> hex digest
+	ETag          string            `json:"etag,omitempty"`
+	LastModified  string            `json:"last_modified,omitempty"`
+	FetchedAt     time.Time         `json:"fetched_at"`
+	CheckedAt     time.Time         `json:"checked_at"`              // last fetch or successful revalidation
+	AccessedAt    time.Time         `json:"accessed_at,omitempty"`   // last time the entry was returned
+	Postprocessed []string          `json:"postprocessed,omitempty"` // names of postprocessors that succeeded
+	Consumed      bool              `json:"consumed,omitempty"`      // a postprocessor removed the file (e.g. after extracting it)
+}
+
+// postprocessed reports whether the named postprocessor already succeeded.
+func (e *CacheEntry) postprocessed(name string) bool {
+	if e == nil {
+		return false
+	}
+	for _, p := range e.Postprocessed {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// expired reports whether the entry must be revalidated given maxAge.
+// A zero maxAge means entries never expire.
+func (e *CacheEntry) expired(maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	if e == nil {
+		return true
+	}
+	return time.Since(e.CheckedAt) > maxAge
+}
+
+// CacheOptions holds settings for CachedDownloadWithOptions.
+type CacheOptions struct {
+	DownloadOptions
+	MaxAge      time.Duration // revalidate entries older than this; 0 means never expire
+	Revalidate  bool          // always revalidate existing entries with the server
+	LockTimeout time.Duration // wait for concurrent downloads of the same entry; 0 means DefaultLockTimeout
+	Mirrors     []string      // fallback URLs of the same file, tried in order when the URL fails
+	RaceMirrors bool          // download from the URL and every mirror at once, keeping the first verified result
+}
+
+// CacheResult describes the file returned by CachedDownloadWithOptions.
+type CacheResult struct {
+	Path   string
+	Status CacheStatus
+	Entry  *CacheEntry
+	Source string // the URL or mirror that served a download; empty for cache hits
+}
+
+// cacheFilename converts a URL into the default cache file name.
+func cacheFilename(urlStr string) string {
+	return strings.NewReplacer("/", "-SLASH-", ":", "-COLON-", "=", "-EQUAL-", "?", "-QUESTION-").Replace(urlStr)
+}
+
+// cacheIndexKey returns the name of the index record for a cached file.
+func cacheIndexKey(outputPath string) string {
+	if abs, err := filepath.Abs(outputPath); err == nil {
+		outputPath = abs
+	}
+	sum := sha256.Sum256([]byte(outputPath))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheIndexPath returns the path of the index record for a cached file.
+func cacheIndexPath(cacheRoot, outputPath string) string {
+	return filepath.Join(cacheRoot, "index", cacheIndexKey(outputPath)+".json")
+}
+
+// readCacheEntry loads the index record for outputPath; it returns nil if
+// there is none.
+func readCacheEntry(cacheRoot, outputPath string) (*CacheEntry, error) {
+	data, err := os.ReadFile(cacheIndexPath(cacheRoot, outputPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("couldn't parse cache entry for %s: %w", outputPath, err)
+	}
+	return &entry, nil
+}
+
+// writeCacheEntry atomically stores the index record for entry.Path.
+func writeCacheEntry(cacheRoot string, entry *CacheEntry) error {
+	indexPath := cacheIndexPath(cacheRoot, entry.Path)
+	if err := os.MkdirAll(filepath.Dir(indexPath), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(indexPath), "entry")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), indexPath); err != nil {
+		return err
+	}
+	return addCacheRefs(cacheRoot, entry)
+}
+
+//
+// Secondary index – find entries by hash without listing the whole cache
+//
+// Every index record is also referenced by an empty file named after its
+// key under index/refs/<ref>/, where ref is e.g. "md5/<digest>". References
+// are only hints: lookups check the record they point to, so references
+// left behind by rewritten records are harmless.
+//
+
+// cacheRefs returns the secondary index references of entry: its hashes,
+// its URL and its Google Drive file ID.
+func cacheRefs(entry *CacheEntry) []string {
+	var refs []string
+	for algo, sum := range entry.Hashes {
+		refs = append(refs, path.Join(algo, sum))
+	}
+	refs = append(refs, urlRef(entry.URL))
+	if id, _, err := ParseUrl(entry.URL, false); err == nil && id != "" {
+		refs = append(refs, idRef(id))
+	}
+	return refs
+}
+
+// urlRef returns the secondary index reference of a URL.
+func urlRef(urlStr string) string {
+	sum := sha256.Sum256([]byte(urlStr))
+	return path.Join("url", hex.EncodeToString(sum[:]))
+}
+
+// idRef returns the secondary index reference of a Google Drive file ID.
+func idRef(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return path.Join("id", hex.EncodeToString(sum[:]))
+}
+
+// cacheRefDir returns the directory holding the references for ref.
+func cacheRefDir(cacheRoot, ref string) string {
+	return filepath.Join(cacheRoot, "index", "refs", filepath.FromSlash(ref))
+}
+
+// addCacheRefs records the references of entry.
+func addCacheRefs(cacheRoot string, entry *CacheEntry) error {
+	key := cacheIndexKey(entry.Path)
+	for _, ref := range cacheRefs(entry) {
+		dir := cacheRefDir(cacheRoot, ref)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, key), nil, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeCacheRefs deletes the references of entry.
+func removeCacheRefs(cacheRoot string, entry *CacheEntry) {
+	key := cacheIndexKey(entry.Path)
+	for _, ref := range cacheRefs(entry) {
+		dir := cacheRefDir(cacheRoot, ref)
+		_ = os.Remove(filepath.Join(dir, key))
+		// Fails, as wanted, while other entries share the reference.
+		_ = os.Remove(dir)
+	}
+}
+
+// lookupCacheRef returns the entries of the cache in cacheRoot referenced
+// by ref whose file still exists. ok is false if the cache has no secondary
+// index at all, i.e. it was written by an older version and must be listed
+// instead.
+func lookupCacheRef(cacheRoot, ref string) (entries []*CacheEntry, ok bool) {
+	if _, err := os.Stat(filepath.Join(cacheRoot, "index", "refs")); err != nil {
+		return nil, false
+	}
+	keys, _ := os.ReadDir(cacheRefDir(cacheRoot, ref))
+	for _, k := range keys {
+		data, err := os.ReadFile(filepath.Join(cacheRoot, "index", k.Name()+".json"))
+		if err != nil {
+			continue
+		}
+		var entry CacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil || !fileExists(entry.Path) {
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, true
+}
+
+// newCacheEntry builds an index record for a freshly stored file.
+func newCacheEntry(urlStr, outputPath string, header http.Header) (*CacheEntry, error) {
+	hashes, size, err := fileHashes(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	if abs, err := filepath.Abs(outputPath); err == nil {
+		outputPath = abs
+	}
+	now := time.Now().UTC()
+	entry := &CacheEntry{
+		URL:        urlStr,
+		Path:       outputPath,
+		Size:       size,
+		Hashes:     hashes,
+		FetchedAt:  now,
+		CheckedAt:  now,
+		AccessedAt: now,
+	}
+	if header != nil {
+		entry.ETag = header.Get("ETag")
+		entry.LastModified = header.Get("Last-Modified")
+		if header.Get("Content-Disposition") != "" {
+			entry.Name = getFilenameFromResponse(&http.Response{Header: header})
+		}
+	}
+	return entry, nil
+}
+
+// CachedDownloadWithOptions downloads urlStr into the cache (or outputPath)
+// and records its origin, size, hashes and validators in the cache index.
+// Existing files are reused unless they fail the hash check, are older than
+// opts.MaxAge or opts.Revalidate is set; in those cases the server is asked
+// whether the file changed using If-None-Match/If-Modified-Since. In offline
+// mode existing files are always reused and an *OfflineError is returned
+// instead of downloading.
+//
+// If postprocess is named, it runs on any result it hasn't been recorded
+// for yet; unnamed postprocessors only run after a fresh download.
+func CachedDownloadWithOptions(urlStr, outputPath, hash string, postprocess *Postprocessor, opts CacheOptions) (*CacheResult, error) {
+	cacheRoot := getCacheRoot(opts.CacheDir)
+	_ = os.MkdirAll(cacheRoot, os.ModePerm)
+	defaultOutput := outputPath == ""
+	if outputPath == "" {
+		outputPath = filepath.Join(cacheRoot, cacheFilename(urlStr))
+	}
+	// Serialize work on the same entry across processes so that concurrent
+	// callers wait for a single download and then share its result.
+	lock, err := acquireLock(cacheLockPath(cacheRoot, outputPath), opts.LockTimeout, opts.Quiet)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+	res, err := cachedDownload(cacheRoot, urlStr, outputPath, hash, defaultOutput, postprocess, opts)
+	if err != nil {
+		return nil, err
+	}
+	if postprocess == nil {
+		return res, nil
+	}
+	if postprocess.Name == "" {
+		if res.Status == CacheMiss || res.Status == CacheRefetched {
+			if err := runPostprocessor(cacheRoot, postprocess, res); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	if res.Entry.postprocessed(postprocess.Name) {
+		return res, nil
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Postprocessing (%s): %s\n", postprocess.Name, res.Path)
+	}
+	if err := runPostprocessor(cacheRoot, postprocess, res); err != nil {
+		return nil, err
+	}
+	res.Entry.Postprocessed = append(res.Entry.Postprocessed, postprocess.Name)
+	res.Entry.Consumed = postprocess.RemovesFile
+	for _, dir := range getReadOnlyCacheDirs(opts.ReadOnlyDirs) {
+		if isWithin(dir, res.Entry.Path) {
+			// Files served in place from a read-only cache can't be recorded.
+			return res, nil
+		}
+	}
+	if err := writeCacheEntry(cacheRoot, res.Entry); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// runPostprocessor applies postprocess to res.Path, first giving the file
+// its own copy of the data if the step modifies it in place. Steps that
+// remove the file only run once it matches the recorded hash, since it may
+// be the only copy.
+func runPostprocessor(cacheRoot string, postprocess *Postprocessor, res *CacheResult) error {
+	if postprocess.RemovesFile && res.Entry != nil {
+		for _, algo := range []string{"sha256", "md5"} {
+			if sum := res.Entry.Hashes[algo]; sum != "" {
+				if _, err := assertFileHash(res.Path, algo+":"+sum, true); err != nil {
+					return fmt.Errorf("not postprocessing %s: %w", res.Path, err)
+				}
+				break
+			}
+		}
+	}
+	if postprocess.InPlace && res.Entry != nil {
+		if err := unshareBlob(cacheRoot, res.Path, res.Entry.Hashes["sha256"]); err != nil {
+			return err
+		}
+	}
+	return postprocess.apply(res.Path, postprocessDir(cacheRoot, res.Path))
+}
+
+// cachedDownload resolves a cache request while holding the entry lock.
+func cachedDownload(cacheRoot, urlStr, outputPath, hash string, defaultOutput bool, postprocess *Postprocessor, opts CacheOptions) (*CacheResult, error) {
+	entry, err := readCacheEntry(cacheRoot, outputPath)
+	if err != nil && !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Ignoring cache entry: %v\n", err)
+	}
+
+	// A postprocessor that removed the file (e.g. after extracting it) has
+	// already consumed this entry.
+	if !fileExists(outputPath) && postprocess != nil && postprocess.RemovesFile &&
+		entry != nil && entry.URL == urlStr && entry.postprocessed(postprocess.Name) {
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Already postprocessed (%s): %s\n", postprocess.Name, outputPath)
+		}
+		return &CacheResult{Path: outputPath, Status: CacheHit, Entry: entry}, nil
+	}
+
+	offline := isOffline(opts.DownloadOptions)
+	status := CacheMiss
+	var cond *Validators
+	if fileExists(outputPath) {
+		status = CacheRefetched
+		if hash != "" {
+			if ok, _ := assertFileHash(outputPath, hash, opts.Quiet); ok {
+				return cacheHit(cacheRoot, urlStr, outputPath, entry)
+			}
+			if offline {
+				fmt.Fprintf(os.Stderr, "Hash mismatch: %s\n", outputPath)
+			} else {
+				fmt.Fprintf(os.Stderr, "Hash mismatch, redownloading: %s\n", outputPath)
+			}
+		} else if offline || (!opts.Revalidate && !entry.expired(opts.MaxAge)) {
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "File exists: %s\n", outputPath)
+			}
+			return cacheHit(cacheRoot, urlStr, outputPath, entry)
+		} else if entry != nil && entry.URL == urlStr && (entry.ETag != "" || entry.LastModified != "") {
+			cond = &Validators{ETag: entry.ETag, LastModified: entry.LastModified}
+		}
+	}
+
+	if hash != "" && cond == nil {
+		if res, err := blobCacheHit(cacheRoot, urlStr, outputPath, hash, defaultOutput, opts); err != nil || res != nil {
+			return res, err
+		}
+	}
+	if cond == nil {
+		if res, err := readOnlyCacheHit(urlStr, outputPath, hash, defaultOutput, opts); err != nil || res != nil {
+			return res, err
+		}
+	}
+
+	if offline {
+		return nil, &OfflineError{Missing: []string{urlStr}}
+	}
+
+	tmpDir, err := os.MkdirTemp(cacheRoot, "dl")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	tempPath := filepath.Join(tmpDir, "dl")
+	client, err := newHTTPClient(opts.DownloadOptions)
+	if err != nil {
+		return nil, err
+	}
+	sources := append([]string{urlStr}, opts.Mirrors...)
+	dl, source, err := downloadSources(client, sources, tempPath, hash, opts.DownloadOptions, cond, opts.RaceMirrors)
+	if err != nil {
+		return nil, err
+	}
+	if dl.NotModified {
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Not modified: %s\n", outputPath)
+		}
+		entry.CheckedAt = time.Now().UTC()
+		entry.AccessedAt = entry.CheckedAt
+		if err := writeCacheEntry(cacheRoot, entry); err != nil {
+			return nil, err
+		}
+		return &CacheResult{Path: outputPath, Status: CacheRevalidated, Entry: entry, Source: source}, nil
+	}
+	if err := os.Rename(dl.Path, outputPath); err != nil {
+		return nil, err
+	}
+	if hash != "" {
+		if ok, err := assertFileHash(outputPath, hash, opts.Quiet); err != nil || !ok {
+			return nil, fmt.Errorf("hash mismatch for file %s", outputPath)
+		}
+	}
+	entry, err = newCacheEntry(urlStr, outputPath, dl.Header)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCacheEntry(cacheRoot, entry); err != nil {
+		return nil, err
+	}
+	if defaultOutput {
+		// Deduplicate against content already cached under other URLs.
+		_ = storeBlob(cacheRoot, outputPath, entry.Hashes["sha256"])
+	}
+	return &CacheResult{Path: outputPath, Status: status, Entry: entry, Source: source}, nil
+}
+
+// cacheHit returns a hit result, recording an index entry for files cached
+// before the index existed.
+func cacheHit(cacheRoot, urlStr, outputPath string, entry *CacheEntry) (*CacheResult, error) {
+	if entry == nil {
+		var err error
+		entry, err = newCacheEntry(urlStr, outputPath, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	entry.AccessedAt = time.Now().UTC()
+	if err := writeCacheEntry(cacheRoot, entry); err != nil {
+		return nil, err
+	}
+	return &CacheResult{Path: outputPath, Status: CacheHit, Entry: entry}, nil
+}
+
+// readOnlyCacheHit looks for urlStr in the read-only cache directories. When
+// the caller asked for the default cache location, the file is returned in
+// place; otherwise it is copied to outputPath. It returns nil if no read-only
+// cache holds a usable copy.
+func readOnlyCacheHit(urlStr, outputPath, hash string, defaultOutput bool, opts CacheOptions) (*CacheResult, error) {
+	for _, dir := range getReadOnlyCacheDirs(opts.ReadOnlyDirs) {
+		candidate := filepath.Join(dir, cacheFilename(urlStr))
+		if !fileExists(candidate) {
+			continue
+		}
+		if hash != "" {
+			if ok, _ := assertFileHash(candidate, hash, opts.Quiet); !ok {
+				continue
+			}
+		}
+		if !opts.Quiet {
+			fmt.Fprintf(os.Stderr, "Found in read-only cache: %s\n", candidate)
+		}
+		entry, _ := readCacheEntry(dir, candidate)
+		if entry == nil {
+			info, err := os.Stat(candidate)
+			if err != nil {
+				continue
+			}
+			entry = &CacheEntry{URL: urlStr, Path: candidate, Size: info.Size(), FetchedAt: info.ModTime().UTC(), CheckedAt: info.ModTime().UTC()}
+		}
+		if defaultOutput {
+			return &CacheResult{Path: candidate, Status: CacheHit, Entry: entry}, nil
+		}
+		if err := copyFile(candidate, outputPath); err != nil {
+			return nil, err
+		}
+		cacheRoot := getCacheRoot(opts.CacheDir)
+		local, err := newCacheEntry(urlStr, outputPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		local.Name, local.ETag, local.LastModified = entry.Name, entry.ETag, entry.LastModified
+		if err := writeCacheEntry(cacheRoot, local); err != nil {
+			return nil, err
+		}
+		return &CacheResult{Path: outputPath, Status: CacheHit, Entry: local}, nil
+	}
+	return nil, nil
+}
+
+// copyFile copies src to dst through a temporary file in dst's directory.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".gdown-copy")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+//
+// Cache management (list, info, verify, remove, garbage collection)
+//
+
+// uncacheFilename reverses cacheFilename for files cached without an index.
+func uncacheFilename(name string) string {
+	return strings.NewReplacer("-SLASH-", "/", "-COLON-", ":", "-EQUAL-", "=", "-QUESTION-", "?").Replace(name)
+}
+
+// isCacheInternal reports whether a name in the cache root belongs to the
+// cache itself rather than to a cached file.
+func isCacheInternal(name string) bool {
+	return name == "index" || name == "locks" || name == "blobs" || name == "folders" || name == "tokens" || name == "local" || name == "extracted" || strings.HasPrefix(name, "dl")
+}
+
+// ListCache returns every entry of the cache in dir (empty for the default)
+// whose file is stored in the cache directory, sorted by URL. Files stored
+// in the cache root before the index existed are included with the URL
+// derived from their file name.
+func ListCache(dir string) ([]*CacheEntry, error) {
+	cacheRoot := getCacheRoot(dir)
+	records, err := listCacheRecords(cacheRoot)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*CacheEntry
+	for _, entry := range records {
+		if isWithin(cacheRoot, entry.Path) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// listCacheRecords returns the entries of every index record in cacheRoot
+// whose file exists, including files outside the cache directory (cached
+// with an explicit output path), and the legacy files of the cache root,
+// sorted by URL.
+func listCacheRecords(cacheRoot string) ([]*CacheEntry, error) {
+	var entries []*CacheEntry
+	indexed := map[string]bool{}
+	records, err := os.ReadDir(filepath.Join(cacheRoot, "index"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, rec := range records {
+		if rec.IsDir() || !strings.HasSuffix(rec.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(cacheRoot, "index", rec.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var entry CacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			continue
+		}
+		if !fileExists(entry.Path) {
+			continue
+		}
+		indexed[entry.Path] = true
+		entries = append(entries, &entry)
+	}
+	files, err := os.ReadDir(cacheRoot)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || isCacheInternal(f.Name()) {
+			continue
+		}
+		p := filepath.Join(cacheRoot, f.Name())
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		if indexed[p] {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, &CacheEntry{
+			URL:       uncacheFilename(f.Name()),
+			Path:      p,
+			Size:      info.Size(),
+			FetchedAt: info.ModTime().UTC(),
+			CheckedAt: info.ModTime().UTC(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].URL != entries[j].URL {
+			return entries[i].URL < entries[j].URL
+		}
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// matchPattern reports whether s matches pattern, where '*' matches any
+// sequence of characters (including '/') and '?' matches a single one.
+func matchPattern(pattern, s string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, `.*`)
+	expr = strings.ReplaceAll(expr, `\?`, `.`)
+	ok, _ := regexp.MatchString("^"+expr+"$", s)
+	return ok
+}
+
+// FindCache returns the entries whose URL or path matches pattern.
+func FindCache(dir, pattern string) ([]*CacheEntry, error) {
+	entries, err := ListCache(dir)
+	if err != nil {
+		return nil, err
+	}
+	return matchEntries(entries, pattern), nil
+}
+
+// matchEntries returns the entries whose URL or path matches pattern.
+func matchEntries(entries []*CacheEntry, pattern string) []*CacheEntry {
+	var matched []*CacheEntry
+	for _, entry := range entries {
+		if matchPattern(pattern, entry.URL) || matchPattern(pattern, entry.Path) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// CacheVerifyResult holds the outcome of verifying one cache entry.
+type CacheVerifyResult struct {
+	Entry *CacheEntry
+	OK    bool
+	Err   error // non-nil if the file couldn't be read or a hash differs
+}
+
+// VerifyCache recomputes the hashes of every indexed entry and compares them
+// with the stored ones. Entries without stored hashes are only checked for
+// size.
+func VerifyCache(dir string) ([]CacheVerifyResult, error) {
+	entries, err := ListCache(dir)
+	if err != nil {
+		return nil, err
+	}
+	var results []CacheVerifyResult
+	for _, entry := range entries {
+		res := CacheVerifyResult{Entry: entry}
+		hashes, size, err := fileHashes(entry.Path)
+		switch {
+		case err != nil:
+			res.Err = err
+		case size != entry.Size:
+			res.Err = fmt.Errorf("size mismatch: actual %d, expected %d", size, entry.Size)
+		default:
+			res.OK = true
+			for algo, expected := range entry.Hashes {
+				if actual, ok := hashes[algo]; ok && actual != expected {
+					res.OK = false
+					res.Err = fmt.Errorf("%s mismatch: actual %s, expected %s", algo, actual, expected)
+					break
+				}
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// removeCacheEntry deletes a cached file and its index record. Files
+// outside the cache directory aren't the cache's to delete; only their
+// record is removed.
+func removeCacheEntry(cacheRoot string, entry *CacheEntry) error {
+	if isWithin(cacheRoot, entry.Path) {
+		if err := os.Remove(entry.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Remove(cacheIndexPath(cacheRoot, entry.Path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	removeCacheRefs(cacheRoot, entry)
+	return nil
+}
+
+// RemoveCache deletes the entries whose URL or path matches pattern and
+// returns them. Records of files outside the cache directory are dropped,
+// but the files are left alone.
+func RemoveCache(dir, pattern string) ([]*CacheEntry, error) {
+	cacheRoot := getCacheRoot(dir)
+	records, err := listCacheRecords(cacheRoot)
+	if err != nil {
+		return nil, err
+	}
+	matched := matchEntries(records, pattern)
+	for _, entry := range matched {
+		if err := removeCacheEntry(cacheRoot, entry); err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
+}
+
+// CacheGCOptions holds settings for GCCache.
+type CacheGCOptions struct {
+	MaxSize int64         // evict least recently used entries until the total size fits; 0 means no limit
+	MaxAge  time.Duration // evict entries not used for longer than this; 0 means no limit
+	DryRun  bool          // report what would be evicted without deleting
+}
+
+// lastUsed returns the time the entry was last returned or fetched.
+func (e *CacheEntry) lastUsed() time.Time {
+	if e.AccessedAt.After(e.FetchedAt) {
+		return e.AccessedAt
+	}
+	return e.FetchedAt
+}
+
+// gcGroup is a set of cache entries sharing the same file data (aliases of
+// one blob), which is only freed once all of them are evicted.
+type gcGroup struct {
+	info     os.FileInfo
+	size     int64
+	lastUsed time.Time
+	entries  []*CacheEntry
+}
+
+// groupByFile groups entries whose paths are hard links to the same file.
+func groupByFile(entries []*CacheEntry) []*gcGroup {
+	var groups []*gcGroup
+	bySize := map[int64][]*gcGroup{}
+	for _, entry := range entries {
+		info, err := os.Stat(entry.Path)
+		if err != nil {
+			continue
+		}
+		var g *gcGroup
+		for _, candidate := range bySize[info.Size()] {
+			if os.SameFile(candidate.info, info) {
+				g = candidate
+				break
+			}
+		}
+		if g == nil {
+			g = &gcGroup{info: info, size: info.Size()}
+			bySize[g.size] = append(bySize[g.size], g)
+			groups = append(groups, g)
+		}
+		g.entries = append(g.entries, entry)
+		if entry.lastUsed().After(g.lastUsed) {
+			g.lastUsed = entry.lastUsed()
+		}
+	}
+	return groups
+}
+
+// GCCache evicts cache entries by age and total size budget (least recently
+// used first) and removes index records whose file is gone as well as blobs
+// no longer referenced by any entry. Only files stored inside the cache
+// directory are evicted. Entries sharing a blob are counted once against
+// the budget, and are evicted together when it is exceeded. It returns the
+// evicted entries and the number of bytes freed.
+func GCCache(dir string, opts CacheGCOptions) ([]*CacheEntry, int64, error) {
+	cacheRoot := getCacheRoot(dir)
+	for _, root := range []string{cacheRoot, localRecordsRoot(cacheRoot)} {
+		if err := pruneCacheIndex(root, opts.DryRun); err != nil {
+			return nil, 0, err
+		}
+	}
+	entries, err := ListCache(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var candidates []*CacheEntry
+	for _, entry := range entries {
+		if isWithin(cacheRoot, entry.Path) {
+			candidates = append(candidates, entry)
+		}
+	}
+	groups := groupByFile(candidates)
+	var total int64
+	for _, g := range groups {
+		total += g.size
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].lastUsed.Before(groups[j].lastUsed)
+	})
+	var evicted []*CacheEntry
+	var freed int64
+	for _, g := range groups {
+		over := opts.MaxSize > 0 && total > opts.MaxSize
+		kept := 0
+		for _, entry := range g.entries {
+			old := opts.MaxAge > 0 && time.Since(entry.lastUsed()) > opts.MaxAge
+			if !old && !over {
+				kept++
+				continue
+			}
+			if !opts.DryRun {
+				if err := removeCacheEntry(cacheRoot, entry); err != nil {
+					return evicted, freed, err
+				}
+			}
+			evicted = append(evicted, entry)
+		}
+		if kept == 0 {
+			total -= g.size
+			freed += g.size
+		}
+	}
+	if !opts.DryRun {
+		remaining, err := ListCache(dir)
+		if err != nil {
+			return evicted, freed, err
+		}
+		if err := pruneBlobs(cacheRoot, remaining); err != nil {
+			return evicted, freed, err
+		}
+	}
+	return evicted, freed, nil
+}
+
+// pruneCacheIndex removes index records whose cached file no longer exists,
+// except those of files removed by a postprocessor.
+func pruneCacheIndex(cacheRoot string, dryRun bool) error {
+	indexDir := filepath.Join(cacheRoot, "index")
+	records, err := os.ReadDir(indexDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if rec.IsDir() || !strings.HasSuffix(rec.Name(), ".json") {
+			continue
+		}
+		p := filepath.Join(indexDir, rec.Name())
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var entry CacheEntry
+		parsed := json.Unmarshal(data, &entry) == nil
+		// Consumed entries record that the file was postprocessed away
+		// and mustn't be fetched again.
+		if parsed && (entry.Consumed || fileExists(entry.Path)) {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if parsed {
+				removeCacheRefs(cacheRoot, &entry)
+			}
+		}
+	}
+	return nil
+}
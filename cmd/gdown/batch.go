@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/igolaizola/gdown"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/peterbourgon/ff/v3/ffyaml"
+)
+
+// batchReportEntry is the JSON form of a batch result.
+type batchReportEntry struct {
+	URL    string `json:"url"`
+	Output string `json:"output,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Status string `json:"status"`
+	Source string `json:"source,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newBatchCommand() *ffcli.Command {
+	cmd := "batch"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	input := fs.String("input", "-", "Batch list file, one URL per line with optional output, hash and format columns, or JSONL (- for stdin)")
+	inputFormat := fs.String("input-format", "", "Input format: list, metalink or aria2 (default detected from the content)")
+	dir := fs.String("dir", "", "Base directory for relative outputs (default current directory)")
+	concurrency := fs.Int("concurrency", 4, "Number of parallel downloads")
+	overwrite := fs.Bool("overwrite", false, "Download even if the output already exists")
+	race := fs.Bool("race", false, "Download items with mirrors from every source at once")
+	report := fs.String("report", "", "Write a JSON report of the results to this file")
+	quiet := fs.Bool("quiet", false, "Suppress logging")
+	proxy := fs.String("proxy", "", "Proxy URL")
+	speed := fs.Int64("speed", 0, "Download speed limit per file (bytes/sec)")
+	noCookies := fs.Bool("no-cookies", false, "Do not use cookies")
+	cookies := fs.String("cookies", "", "Netscape cookies.txt file to load cookies from (e.g. exported from a logged-in browser)")
+	saveCookies := fs.Bool("save-cookies", false, "Write updated cookies back to the -cookies file")
+	noVerify := fs.Bool("no-verify", false, "Do not verify TLS certificate")
+	fuzzy := fs.Bool("fuzzy", false, "Download files linked by view or open links through their direct download link (Google Drive only)")
+	format := fs.String("format", "", "Default format of Google Docs/Sheets/Slides (e.g. docx, xlsx, pptx)")
+	userAgent := fs.String("user-agent", "", "User-Agent to use")
+	apiKey := fs.String("api-key", "", "Google Drive API v3 key; access Drive through the REST API instead of web pages")
+	credentials := fs.String("credentials", "", "Service account key or OAuth2 client ID JSON file to access private items")
+	cacheDir := fs.String("cache-dir", "", "Cache directory consulted in offline mode")
+	offline := fs.Bool("offline", false, "Never access the network; copy from cache or fail")
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown %s [flags]", cmd),
+		ShortHelp:  "Download every file of a list",
+		FlagSet:    fs,
+		Options: []ff.Option{
+			ff.WithEnvVarPrefix("GDOWN"),
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ffyaml.Parser),
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			var r io.Reader = os.Stdin
+			if *input != "-" {
+				f, err := os.Open(*input)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			items, err := gdown.ParseBatchInput(r, *inputFormat)
+			if err != nil {
+				return err
+			}
+			ts, err := tokenSource(*credentials, *cacheDir, *quiet)
+			if err != nil {
+				return err
+			}
+			opts := gdown.BatchOptions{
+				DownloadOptions: gdown.DownloadOptions{
+					Quiet:       *quiet,
+					Proxy:       *proxy,
+					Speed:       *speed,
+					UseCookies:  !(*noCookies),
+					CookiesFile: *cookies,
+					SaveCookies: *saveCookies,
+					Verify:      !(*noVerify),
+					Fuzzy:       *fuzzy,
+					Format:      *format,
+					UserAgent:   *userAgent,
+					APIKey:      *apiKey,
+					TokenSource: ts,
+					CacheDir:    *cacheDir,
+					Offline:     *offline,
+				},
+				Concurrency: *concurrency,
+				Dir:         *dir,
+				Overwrite:   *overwrite,
+				RaceMirrors: *race,
+			}
+			results, err := gdown.DownloadBatch(items, opts)
+			if err != nil {
+				return err
+			}
+
+			counts := map[gdown.BatchStatus]int{}
+			var entries []batchReportEntry
+			for _, res := range results {
+				counts[res.Status]++
+				entry := batchReportEntry{
+					URL:    res.Item.URL,
+					Output: res.Item.Output,
+					Path:   res.Path,
+					Status: string(res.Status),
+					Source: res.Source,
+				}
+				switch res.Status {
+				case gdown.BatchFailed:
+					entry.Error = res.Err.Error()
+					fmt.Printf("FAILED      %s: %v\n", res.Item.URL, res.Err)
+				case gdown.BatchSkipped:
+					fmt.Printf("SKIPPED     %s -> %s\n", res.Item.URL, res.Path)
+				default:
+					fmt.Printf("DOWNLOADED  %s -> %s\n", res.Source, res.Path)
+				}
+				entries = append(entries, entry)
+			}
+			fmt.Printf("%d downloaded, %d skipped, %d failed\n",
+				counts[gdown.BatchDownloaded], counts[gdown.BatchSkipped], counts[gdown.BatchFailed])
+
+			if *report != "" {
+				data, err := json.MarshalIndent(entries, "", "  ")
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(*report, data, 0644); err != nil {
+					return err
+				}
+			}
+			if n := counts[gdown.BatchFailed]; n > 0 {
+				return fmt.Errorf("%d of %d downloads failed", n, len(results))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/igolaizola/gdown"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/peterbourgon/ff/v3/ffyaml"
+)
+
+func newCacheCommand() *ffcli.Command {
+	cmd := "cache"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown %s <subcommand>", cmd),
+		ShortHelp:  "Manage the download cache",
+		FlagSet:    fs,
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			newCacheLsCommand(),
+			newCacheInfoCommand(),
+			newCacheVerifyCommand(),
+			newCacheRmCommand(),
+			newCacheGCCommand(),
+			newCacheDedupCommand(),
+		},
+	}
+}
+
+// cacheDirFlag registers the -cache-dir flag shared by cache subcommands.
+func cacheDirFlag(fs *flag.FlagSet) *string {
+	return fs.String("cache-dir", "", "Cache directory (default $XDG_CACHE_HOME/gdown or ~/.cache/gdown)")
+}
+
+// cacheOptions returns the ff options shared by cache subcommands.
+func cacheOptions() []ff.Option {
+	return []ff.Option{
+		ff.WithEnvVarPrefix("GDOWN"),
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ffyaml.Parser),
+	}
+}
+
+func newCacheLsCommand() *ffcli.Command {
+	cmd := "ls"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cacheDir := cacheDirFlag(fs)
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown cache %s", cmd),
+		ShortHelp:  "List cached files with size and origin URL",
+		FlagSet:    fs,
+		Options:    cacheOptions(),
+		Exec: func(ctx context.Context, args []string) error {
+			entries, err := gdown.ListCache(*cacheDir)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SIZE\tFETCHED\tURL")
+			var total int64
+			for _, e := range entries {
+				fmt.Fprintf(w, "%d\t%s\t%s\n", e.Size, e.FetchedAt.Format(time.RFC3339), e.URL)
+				total += e.Size
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			fmt.Printf("%d entries, %d bytes\n", len(entries), total)
+			return nil
+		},
+	}
+}
+
+func newCacheInfoCommand() *ffcli.Command {
+	cmd := "info"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cacheDir := cacheDirFlag(fs)
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown cache %s <url-or-pattern>", cmd),
+		ShortHelp:  "Show the metadata of cached files",
+		FlagSet:    fs,
+		Options:    cacheOptions(),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("a URL or pattern is required")
+			}
+			entries, err := gdown.FindCache(*cacheDir, args[0])
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				return fmt.Errorf("no cache entries match %s", args[0])
+			}
+			for i, e := range entries {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("URL: %s\n", e.URL)
+				fmt.Printf("Path: %s\n", e.Path)
+				fmt.Printf("Size: %d\n", e.Size)
+				for _, algo := range []string{"md5", "sha256"} {
+					if sum, ok := e.Hashes[algo]; ok {
+						fmt.Printf("%s: %s\n", algo, sum)
+					}
+				}
+				if e.ETag != "" {
+					fmt.Printf("ETag: %s\n", e.ETag)
+				}
+				if e.LastModified != "" {
+					fmt.Printf("Last-Modified: %s\n", e.LastModified)
+				}
+				fmt.Printf("Fetched: %s\n", e.FetchedAt.Format(time.RFC3339))
+				fmt.Printf("Checked: %s\n", e.CheckedAt.Format(time.RFC3339))
+				if !e.AccessedAt.IsZero() {
+					fmt.Printf("Accessed: %s\n", e.AccessedAt.Format(time.RFC3339))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func newCacheVerifyCommand() *ffcli.Command {
+	cmd := "verify"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cacheDir := cacheDirFlag(fs)
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown cache %s", cmd),
+		ShortHelp:  "Verify cached files against their stored hashes",
+		FlagSet:    fs,
+		Options:    cacheOptions(),
+		Exec: func(ctx context.Context, args []string) error {
+			results, err := gdown.VerifyCache(*cacheDir)
+			if err != nil {
+				return err
+			}
+			var failed int
+			for _, r := range results {
+				if r.OK {
+					fmt.Printf("OK      %s\n", r.Entry.URL)
+					continue
+				}
+				failed++
+				fmt.Printf("FAILED  %s: %v\n", r.Entry.URL, r.Err)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d cache entries failed verification", failed, len(results))
+			}
+			return nil
+		},
+	}
+}
+
+func newCacheRmCommand() *ffcli.Command {
+	cmd := "rm"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cacheDir := cacheDirFlag(fs)
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown cache %s <url-or-pattern>...", cmd),
+		ShortHelp:  "Remove cached files by URL or pattern ('*' matches anything)",
+		FlagSet:    fs,
+		Options:    cacheOptions(),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("at least one URL or pattern is required")
+			}
+			for _, pattern := range args {
+				removed, err := gdown.RemoveCache(*cacheDir, pattern)
+				if err != nil {
+					return err
+				}
+				if len(removed) == 0 {
+					fmt.Fprintf(os.Stderr, "No cache entries match %s\n", pattern)
+				}
+				for _, e := range removed {
+					fmt.Printf("Removed %s\n", e.URL)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func newCacheGCCommand() *ffcli.Command {
+	cmd := "gc"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	maxSize := fs.Int64("max-size", 0, "Evict least recently used files until the cache fits in this many bytes (0 means no limit)")
+	evictAfter := fs.Duration("evict-after", 0, "Evict files not used for longer than this (e.g. 720h, 0 means no limit)")
+	dryRun := fs.Bool("dry-run", false, "Print what would be evicted without deleting")
+	cacheDir := cacheDirFlag(fs)
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown cache %s [flags]", cmd),
+		ShortHelp:  "Garbage-collect the cache by size budget or age",
+		FlagSet:    fs,
+		Options:    cacheOptions(),
+		Exec: func(ctx context.Context, args []string) error {
+			if *maxSize <= 0 && *evictAfter <= 0 {
+				return fmt.Errorf("either -max-size or -evict-after must be specified")
+			}
+			evicted, freed, err := gdown.GCCache(*cacheDir, gdown.CacheGCOptions{
+				MaxSize: *maxSize,
+				MaxAge:  *evictAfter,
+				DryRun:  *dryRun,
+			})
+			if err != nil {
+				return err
+			}
+			for _, e := range evicted {
+				fmt.Printf("Evicted %s (%d bytes)\n", e.URL, e.Size)
+			}
+			fmt.Printf("%d entries, %d bytes freed\n", len(evicted), freed)
+			return nil
+		},
+	}
+}
+
+func newCacheDedupCommand() *ffcli.Command {
+	cmd := "dedup"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	cacheDir := cacheDirFlag(fs)
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown cache %s", cmd),
+		ShortHelp:  "Store cached files by content hash, sharing identical ones",
+		FlagSet:    fs,
+		Options:    cacheOptions(),
+		Exec: func(ctx context.Context, args []string) error {
+			saved, err := gdown.DedupCache(*cacheDir)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d bytes reclaimed\n", saved)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/igolaizola/gdown"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/peterbourgon/ff/v3/ffyaml"
+)
+
+func newFetchCommand() *ffcli.Command {
+	cmd := "fetch"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	manifest := fs.String("manifest", "gdown.yaml", "Manifest file declaring the artifacts (YAML or JSON)")
+	lockfile := fs.String("lockfile", "", "Lockfile path (default the manifest path with a .lock extension)")
+	update := fs.Bool("update", false, "Re-resolve every artifact and rewrite the lockfile instead of verifying against it")
+	frozen := fs.Bool("frozen", false, "Fail if an artifact is missing from the lockfile instead of adding it")
+	quiet := fs.Bool("quiet", false, "Suppress logging")
+	proxy := fs.String("proxy", "", "Proxy URL")
+	speed := fs.Int64("speed", 0, "Download speed limit (bytes/sec)")
+	noCookies := fs.Bool("no-cookies", false, "Do not use cookies")
+	cookies := fs.String("cookies", "", "Netscape cookies.txt file to load cookies from (e.g. exported from a logged-in browser)")
+	saveCookies := fs.Bool("save-cookies", false, "Write updated cookies back to the -cookies file")
+	noVerify := fs.Bool("no-verify", false, "Do not verify TLS certificate")
+	userAgent := fs.String("user-agent", "", "User-Agent to use")
+	apiKey := fs.String("api-key", "", "Google Drive API v3 key; access Drive through the REST API instead of web pages")
+	credentials := fs.String("credentials", "", "Service account key or OAuth2 client ID JSON file to access private items")
+	maxAge := fs.Duration("max-age", 0, "Revalidate cached files older than this (e.g. 24h, 0 means never expire)")
+	cacheDir := fs.String("cache-dir", "", "Cache directory (default $XDG_CACHE_HOME/gdown or ~/.cache/gdown)")
+	lockTimeout := fs.Duration("lock-timeout", gdown.DefaultLockTimeout, "How long to wait for another process downloading the same file")
+	readOnlyDirs := fs.String("cache-readonly-dirs", "", "Read-only cache directories consulted before downloading, separated by the OS path list separator")
+	offline := fs.Bool("offline", false, "Never access the network; serve from cache or fail")
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown %s [flags]", cmd),
+		ShortHelp:  "Fetch the artifacts of a manifest and verify them against its lockfile",
+		FlagSet:    fs,
+		Options: []ff.Option{
+			ff.WithEnvVarPrefix("GDOWN"),
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ffyaml.Parser),
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			m, err := gdown.LoadManifest(*manifest)
+			if err != nil {
+				return err
+			}
+			lockPath := *lockfile
+			if lockPath == "" {
+				lockPath = gdown.LockfilePath(*manifest)
+			}
+			lock, err := gdown.LoadLockfile(lockPath)
+			if err != nil {
+				return err
+			}
+			ts, err := tokenSource(*credentials, *cacheDir, *quiet)
+			if err != nil {
+				return err
+			}
+			opts := gdown.FetchOptions{
+				CacheOptions: gdown.CacheOptions{
+					DownloadOptions: gdown.DownloadOptions{
+						Quiet:        *quiet,
+						Proxy:        *proxy,
+						Speed:        *speed,
+						UseCookies:   !(*noCookies),
+						CookiesFile:  *cookies,
+						SaveCookies:  *saveCookies,
+						Verify:       !(*noVerify),
+						UserAgent:    *userAgent,
+						APIKey:       *apiKey,
+						TokenSource:  ts,
+						CacheDir:     *cacheDir,
+						ReadOnlyDirs: filepath.SplitList(*readOnlyDirs),
+						Offline:      *offline,
+					},
+					MaxAge:      *maxAge,
+					LockTimeout: *lockTimeout,
+				},
+				Dir:    filepath.Dir(*manifest),
+				Update: *update,
+				Frozen: *frozen,
+			}
+			results, newLock := gdown.FetchManifest(m, lock, opts)
+			failed := 0
+			for _, res := range results {
+				if res.Err != nil {
+					failed++
+					fmt.Printf("FAILED  %s: %v\n", res.Artifact.Name, res.Err)
+					continue
+				}
+				fmt.Printf("OK      %s (%d file(s))\n", res.Artifact.Name, len(res.Locked.Files))
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d artifacts failed", failed, len(results))
+			}
+			if *frozen {
+				return nil
+			}
+			if err := gdown.WriteLockfile(lockPath, newLock); err != nil {
+				return err
+			}
+			fmt.Printf("Lockfile written to: %s\n", lockPath)
+			return nil
+		},
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/igolaizola/gdown"
 	"github.com/peterbourgon/ff/v3"
@@ -49,13 +53,25 @@ func newCommand() *ffcli.Command {
 			newDownloadCommand(),
 			newCachedDownloadCommand(),
 			newDownloadFolderCommand(),
+			newSyncFolderCommand(),
 			newExtractAllCommand(),
 			newListFolderCommand(),
 			newParseUrlCommand(),
+			newFetchCommand(),
+			newBatchCommand(),
+			newCacheCommand(),
 		},
 	}
 }
 
+// tokenSource loads the credentials file given with -credentials, if any.
+func tokenSource(path, cacheDir string, quiet bool) (gdown.TokenSource, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return gdown.CredentialsFromFile(path, cacheDir, quiet)
+}
+
 func newVersionCommand() *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "version",
@@ -93,11 +109,24 @@ func newDownloadCommand() *ffcli.Command {
 	proxy := fs.String("proxy", "", "Proxy URL (e.g. http://host:port)")
 	speed := fs.Int64("speed", 0, "Download speed limit in bytes/sec (0 means unlimited)")
 	noCookies := fs.Bool("no-cookies", false, "Do not use cookies")
+	cookies := fs.String("cookies", "", "Netscape cookies.txt file to load cookies from (e.g. exported from a logged-in browser)")
+	saveCookies := fs.Bool("save-cookies", false, "Write updated cookies back to the -cookies file")
 	noVerify := fs.Bool("no-verify", false, "Do not verify TLS certificate")
 	resume := fs.Bool("resume", false, "Resume interrupted download")
 	fuzzy := fs.Bool("fuzzy", false, "Fuzzy extraction of file ID (Google Drive only)")
 	format := fs.String("format", "", "Format of Google Docs/Sheets/Slides (e.g. docx, xlsx, pptx)")
 	userAgent := fs.String("user-agent", "", "User-Agent to use for downloading")
+	apiKey := fs.String("api-key", "", "Google Drive API v3 key; access Drive through the REST API instead of web pages")
+	credentials := fs.String("credentials", "", "Service account key or OAuth2 client ID JSON file to access private items")
+	cacheDir := fs.String("cache-dir", "", "Cache directory consulted in offline mode")
+	offline := fs.Bool("offline", false, "Never access the network; copy from cache or fail")
+	hash := fs.String("hash", "", "Expected hash in the format <algo>:<hash_value>")
+	var mirrors []string
+	fs.Func("mirror", "Fallback URL of the same file, tried in order when the previous one fails (repeatable)", func(s string) error {
+		mirrors = append(mirrors, s)
+		return nil
+	})
+	race := fs.Bool("race", false, "Download from the URL and every mirror at once and keep the first verified result")
 	return &ffcli.Command{
 		Name:       cmd,
 		ShortUsage: fmt.Sprintf("gdown %s [flags]", cmd),
@@ -112,22 +141,41 @@ func newDownloadCommand() *ffcli.Command {
 			if *urlFlag == "" {
 				return fmt.Errorf("flag -url is required")
 			}
+			ts, err := tokenSource(*credentials, *cacheDir, *quiet)
+			if err != nil {
+				return err
+			}
 			opts := gdown.DownloadOptions{
-				Quiet:      *quiet,
-				Proxy:      *proxy,
-				Speed:      *speed,
-				UseCookies: !(*noCookies),
-				Verify:     !(*noVerify),
-				Resume:     *resume,
-				Fuzzy:      *fuzzy,
-				Format:     *format,
-				UserAgent:  *userAgent,
-			}
-			result, err := gdown.Download(*urlFlag, *output, opts)
+				Quiet:       *quiet,
+				Proxy:       *proxy,
+				Speed:       *speed,
+				UseCookies:  !(*noCookies),
+				CookiesFile: *cookies,
+				SaveCookies: *saveCookies,
+				Verify:      !(*noVerify),
+				Resume:      *resume,
+				Fuzzy:       *fuzzy,
+				Format:      *format,
+				UserAgent:   *userAgent,
+				APIKey:      *apiKey,
+				TokenSource: ts,
+				CacheDir:    *cacheDir,
+				Offline:     *offline,
+			}
+			if len(mirrors) == 0 && *hash == "" {
+				result, err := gdown.Download(*urlFlag, *output, opts)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Downloaded file saved to: %s\n", result)
+				return nil
+			}
+			sources := append([]string{*urlFlag}, mirrors...)
+			result, err := gdown.DownloadMirrors(sources, *output, *hash, gdown.MirrorOptions{DownloadOptions: opts, Race: *race})
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Downloaded file saved to: %s\n", result)
+			fmt.Printf("Downloaded file saved to: %s (from %s)\n", result.Path, result.Source)
 			return nil
 		},
 	}
@@ -143,9 +191,26 @@ func newCachedDownloadCommand() *ffcli.Command {
 	proxy := fs.String("proxy", "", "Proxy URL")
 	speed := fs.Int64("speed", 0, "Download speed limit (bytes/sec)")
 	noCookies := fs.Bool("no-cookies", false, "Do not use cookies")
+	cookies := fs.String("cookies", "", "Netscape cookies.txt file to load cookies from (e.g. exported from a logged-in browser)")
+	saveCookies := fs.Bool("save-cookies", false, "Write updated cookies back to the -cookies file")
 	noVerify := fs.Bool("no-verify", false, "Do not verify TLS certificate")
 	resume := fs.Bool("resume", false, "Resume interrupted download")
 	userAgent := fs.String("user-agent", "", "User-Agent to use")
+	apiKey := fs.String("api-key", "", "Google Drive API v3 key; access Drive through the REST API instead of web pages")
+	credentials := fs.String("credentials", "", "Service account key or OAuth2 client ID JSON file to access private items")
+	maxAge := fs.Duration("max-age", 0, "Revalidate cached files older than this (e.g. 24h, 0 means never expire)")
+	revalidate := fs.Bool("revalidate", false, "Always revalidate cached files with the server")
+	cacheDir := fs.String("cache-dir", "", "Cache directory (default $XDG_CACHE_HOME/gdown or ~/.cache/gdown)")
+	lockTimeout := fs.Duration("lock-timeout", gdown.DefaultLockTimeout, "How long to wait for another process downloading the same file")
+	readOnlyDirs := fs.String("cache-readonly-dirs", "", "Read-only cache directories consulted before downloading, separated by the OS path list separator")
+	offline := fs.Bool("offline", false, "Never access the network; serve from cache or fail")
+	postprocess := fs.String("postprocess", "", "Postprocess the cached file: extract[:DIR], extract-delete[:DIR], decompress[:DEST] or chmod+x")
+	var mirrors []string
+	fs.Func("mirror", "Fallback URL of the same file, tried in order when the previous one fails (repeatable)", func(s string) error {
+		mirrors = append(mirrors, s)
+		return nil
+	})
+	race := fs.Bool("race", false, "Download from the URL and every mirror at once and keep the first verified result")
 	return &ffcli.Command{
 		Name:       cmd,
 		ShortUsage: fmt.Sprintf("gdown %s [flags]", cmd),
@@ -160,20 +225,50 @@ func newCachedDownloadCommand() *ffcli.Command {
 			if *urlFlag == "" {
 				return fmt.Errorf("flag -url is required")
 			}
-			opts := gdown.DownloadOptions{
-				Quiet:      *quiet,
-				Proxy:      *proxy,
-				Speed:      *speed,
-				UseCookies: !(*noCookies),
-				Verify:     !(*noVerify),
-				Resume:     *resume,
-				UserAgent:  *userAgent,
-			}
-			result, err := gdown.CachedDownload(*urlFlag, *output, *hash, *quiet, nil, opts)
+			ts, err := tokenSource(*credentials, *cacheDir, *quiet)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Cached download complete. File saved to: %s\n", result)
+			opts := gdown.CacheOptions{
+				DownloadOptions: gdown.DownloadOptions{
+					Quiet:        *quiet,
+					Proxy:        *proxy,
+					Speed:        *speed,
+					UseCookies:   !(*noCookies),
+					CookiesFile:  *cookies,
+					SaveCookies:  *saveCookies,
+					Verify:       !(*noVerify),
+					Resume:       *resume,
+					UserAgent:    *userAgent,
+					APIKey:       *apiKey,
+					TokenSource:  ts,
+					CacheDir:     *cacheDir,
+					ReadOnlyDirs: filepath.SplitList(*readOnlyDirs),
+					Offline:      *offline,
+				},
+				MaxAge:      *maxAge,
+				Revalidate:  *revalidate,
+				LockTimeout: *lockTimeout,
+				Mirrors:     mirrors,
+				RaceMirrors: *race,
+			}
+			var pp *gdown.Postprocessor
+			if *postprocess != "" {
+				var err error
+				pp, err = gdown.ParsePostprocessor(*postprocess)
+				if err != nil {
+					return err
+				}
+			}
+			result, err := gdown.CachedDownloadWithOptions(*urlFlag, *output, *hash, pp, opts)
+			if err != nil {
+				return err
+			}
+			if result.Source != "" && result.Source != *urlFlag {
+				fmt.Printf("Cached download complete (%s from %s). File saved to: %s\n", result.Status, result.Source, result.Path)
+				return nil
+			}
+			fmt.Printf("Cached download complete (%s). File saved to: %s\n", result.Status, result.Path)
 			return nil
 		},
 	}
@@ -190,10 +285,19 @@ func newDownloadFolderCommand() *ffcli.Command {
 	proxy := fs.String("proxy", "", "Proxy URL")
 	speed := fs.Int64("speed", 0, "Download speed limit (bytes/sec)")
 	noCookies := fs.Bool("no-cookies", false, "Do not use cookies")
+	cookies := fs.String("cookies", "", "Netscape cookies.txt file to load cookies from (e.g. exported from a logged-in browser)")
+	saveCookies := fs.Bool("save-cookies", false, "Write updated cookies back to the -cookies file")
 	noVerify := fs.Bool("no-verify", false, "Do not verify TLS certificate")
 	resume := fs.Bool("resume", false, "Resume interrupted downloads")
 	userAgent := fs.String("user-agent", "", "User-Agent to use")
+	apiKey := fs.String("api-key", "", "Google Drive API v3 key; access Drive through the REST API instead of web pages")
+	credentials := fs.String("credentials", "", "Service account key or OAuth2 client ID JSON file to access private items")
 	remainingOk := fs.Bool("remaining-ok", false, "Allow folder contents to reach maximum limit")
+	skipShortcuts := fs.Bool("skip-shortcuts", false, "Leave out shortcuts instead of resolving them to their targets")
+	exportFormats := exportFormatsFlag(fs)
+	cacheDir := fs.String("cache-dir", "", "Cache directory for folder listings and offline mode")
+	offline := fs.Bool("offline", false, "Never access the network; use cached folder listings and files")
+	dryRun := fs.Bool("dry-run", false, "List the planned actions (mkdir, download, resume, overwrite, skip-existing) without writing anything")
 	return &ffcli.Command{
 		Name:       cmd,
 		ShortUsage: fmt.Sprintf("gdown %s [flags]", cmd),
@@ -208,17 +312,37 @@ func newDownloadFolderCommand() *ffcli.Command {
 			if *urlFlag == "" && *id == "" {
 				return fmt.Errorf("either -url or -id must be specified")
 			}
+			ts, err := tokenSource(*credentials, *cacheDir, *quiet)
+			if err != nil {
+				return err
+			}
 			opts := gdown.FolderOptions{
 				DownloadOptions: gdown.DownloadOptions{
-					Quiet:      *quiet,
-					Proxy:      *proxy,
-					Speed:      *speed,
-					UseCookies: !(*noCookies),
-					Verify:     !(*noVerify),
-					Resume:     *resume,
-					UserAgent:  *userAgent,
+					Quiet:       *quiet,
+					Proxy:       *proxy,
+					Speed:       *speed,
+					UseCookies:  !(*noCookies),
+					CookiesFile: *cookies,
+					SaveCookies: *saveCookies,
+					Verify:      !(*noVerify),
+					Resume:      *resume,
+					UserAgent:   *userAgent,
+					APIKey:      *apiKey,
+					TokenSource: ts,
+					CacheDir:    *cacheDir,
+					Offline:     *offline,
 				},
-				RemainingOk: *remainingOk,
+				RemainingOk:   *remainingOk,
+				SkipShortcuts: *skipShortcuts,
+				ExportFormats: exportFormats,
+			}
+			if *dryRun {
+				plan, err := gdown.PlanFolder(*urlFlag, *id, *output, opts)
+				if err != nil {
+					return err
+				}
+				printPlan(plan)
+				return nil
 			}
 			files, err := gdown.DownloadFolder(*urlFlag, *id, *output, opts)
 			if err != nil {
@@ -233,6 +357,47 @@ func newDownloadFolderCommand() *ffcli.Command {
 	}
 }
 
+// printPlan prints the steps of a folder download and their totals.
+func printPlan(plan *gdown.FolderPlan) {
+	for _, a := range plan.Actions {
+		switch {
+		case a.Action == gdown.PlanResume && a.Transfer > 0:
+			fmt.Printf("%-14s %s (%d of %d bytes)\n", strings.ToUpper(string(a.Action)), a.Path, a.Transfer, a.Size)
+		case a.Size > 0:
+			fmt.Printf("%-14s %s (%d bytes)\n", strings.ToUpper(string(a.Action)), a.Path, a.Size)
+		default:
+			fmt.Printf("%-14s %s\n", strings.ToUpper(string(a.Action)), a.Path)
+		}
+	}
+	downloads := plan.Count(gdown.PlanDownload) + plan.Count(gdown.PlanResume) + plan.Count(gdown.PlanOverwrite)
+	total, unknown := plan.TotalBytes()
+	fmt.Printf("Plan: %d directories to create, %d files to download (%d bytes", plan.Count(gdown.PlanMkdir), downloads, total)
+	if unknown > 0 {
+		fmt.Printf(" plus %d of unknown size", unknown)
+	}
+	fmt.Printf("), %d files to skip\n", plan.Count(gdown.PlanSkipExisting))
+}
+
+// exportFormatsFlag registers the repeatable -export-format flag choosing
+// the format of Google-native items by kind.
+func exportFormatsFlag(fs *flag.FlagSet) map[gdown.URLKind]string {
+	formats := map[gdown.URLKind]string{}
+	fs.Func("export-format", "Export format of Google-native items as KIND=FORMAT, e.g. presentation=pdf (repeatable; kinds: document, spreadsheet, presentation, drawing)", func(s string) error {
+		kind, format, ok := strings.Cut(s, "=")
+		if !ok || format == "" {
+			return fmt.Errorf("expected KIND=FORMAT: %s", s)
+		}
+		switch k := gdown.URLKind(kind); k {
+		case gdown.KindDocument, gdown.KindSpreadsheet, gdown.KindPresentation, gdown.KindDrawing:
+			formats[k] = format
+		default:
+			return fmt.Errorf("unknown kind: %s", kind)
+		}
+		return nil
+	})
+	return formats
+}
+
 func newExtractAllCommand() *ffcli.Command {
 	cmd := "extractall"
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
@@ -274,10 +439,19 @@ func newListFolderCommand() *ffcli.Command {
 	proxy := fs.String("proxy", "", "Proxy URL")
 	speed := fs.Int64("speed", 0, "Download speed limit (bytes/sec)")
 	noCookies := fs.Bool("no-cookies", false, "Do not use cookies")
+	cookies := fs.String("cookies", "", "Netscape cookies.txt file to load cookies from (e.g. exported from a logged-in browser)")
+	saveCookies := fs.Bool("save-cookies", false, "Write updated cookies back to the -cookies file")
 	noVerify := fs.Bool("no-verify", false, "Do not verify TLS certificate")
 	resume := fs.Bool("resume", false, "Resume downloads")
 	userAgent := fs.String("user-agent", "", "User-Agent to use")
+	apiKey := fs.String("api-key", "", "Google Drive API v3 key; access Drive through the REST API instead of web pages")
+	credentials := fs.String("credentials", "", "Service account key or OAuth2 client ID JSON file to access private items")
 	remainingOk := fs.Bool("remaining-ok", false, "Allow folder contents to reach maximum limit")
+	skipShortcuts := fs.Bool("skip-shortcuts", false, "Leave out shortcuts instead of resolving them to their targets")
+	exportFormats := exportFormatsFlag(fs)
+	cacheDir := fs.String("cache-dir", "", "Cache directory for folder listings and offline mode")
+	offline := fs.Bool("offline", false, "Never access the network; use cached folder listings and files")
+	metalink := fs.String("metalink", "", "Write the listing as a Metalink 4 file to this path (- for stdout)")
 	return &ffcli.Command{
 		Name:       cmd,
 		ShortUsage: fmt.Sprintf("gdown %s [flags]", cmd),
@@ -292,28 +466,70 @@ func newListFolderCommand() *ffcli.Command {
 			if *urlFlag == "" && *id == "" {
 				return fmt.Errorf("either -url or -id must be specified")
 			}
+			ts, err := tokenSource(*credentials, *cacheDir, *quiet)
+			if err != nil {
+				return err
+			}
 			opts := gdown.FolderOptions{
 				DownloadOptions: gdown.DownloadOptions{
-					Quiet:      *quiet,
-					Proxy:      *proxy,
-					Speed:      *speed,
-					UseCookies: !(*noCookies),
-					Verify:     !(*noVerify),
-					Resume:     *resume,
-					UserAgent:  *userAgent,
+					Quiet:       *quiet,
+					Proxy:       *proxy,
+					Speed:       *speed,
+					UseCookies:  !(*noCookies),
+					CookiesFile: *cookies,
+					SaveCookies: *saveCookies,
+					Verify:      !(*noVerify),
+					Resume:      *resume,
+					UserAgent:   *userAgent,
+					APIKey:      *apiKey,
+					TokenSource: ts,
+					CacheDir:    *cacheDir,
+					Offline:     *offline,
 				},
-				RemainingOk: *remainingOk,
+				RemainingOk:   *remainingOk,
+				SkipShortcuts: *skipShortcuts,
+				ExportFormats: exportFormats,
 			}
 			infos, err := gdown.ListFolder(*urlFlag, *id, opts)
 			if err != nil {
 				return err
 			}
+			if *metalink == "-" {
+				return gdown.WriteMetalink(os.Stdout, infos)
+			}
+			if *metalink != "" {
+				f, err := os.Create(*metalink)
+				if err != nil {
+					return err
+				}
+				if err := gdown.WriteMetalink(f, infos); err != nil {
+					f.Close()
+					return err
+				}
+				if err := f.Close(); err != nil {
+					return err
+				}
+				fmt.Printf("Metalink written to: %s\n", *metalink)
+				return nil
+			}
 			fmt.Println("Folder contents:")
 			for _, info := range infos {
 				if info.IsFolder {
 					fmt.Printf("  Folder: %s\n", info.Path)
 				} else {
 					fmt.Printf("  File: %s\n    Download URL: %s\n", info.Path, info.DownloadURL)
+					if info.Size > 0 {
+						fmt.Printf("    Size: %d\n", info.Size)
+					}
+					if !info.ModifiedTime.IsZero() {
+						fmt.Printf("    Modified: %s\n", info.ModifiedTime.Format(time.RFC3339))
+					}
+					if info.Owner != "" {
+						fmt.Printf("    Owner: %s\n", info.Owner)
+					}
+					if info.MD5Checksum != "" {
+						fmt.Printf("    MD5: %s\n", info.MD5Checksum)
+					}
 				}
 			}
 			return nil
@@ -324,12 +540,13 @@ func newListFolderCommand() *ffcli.Command {
 func newParseUrlCommand() *ffcli.Command {
 	cmd := "parseurl"
 	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
-	urlFlag := fs.String("url", "", "URL to parse (required)")
+	urlFlag := fs.String("url", "", "URL to parse (required unless -scan is used)")
 	warn := fs.Bool("warn", true, "Emit warnings if the URL is not a download link")
+	scan := fs.Bool("scan", false, "Scan the given files (or stdin) for Drive links and bare IDs")
 	return &ffcli.Command{
 		Name:       cmd,
-		ShortUsage: fmt.Sprintf("gdown %s [flags]", cmd),
-		ShortHelp:  "Parse a URL and extract a Google Drive file ID",
+		ShortUsage: fmt.Sprintf("gdown %s [flags] [file...]", cmd),
+		ShortHelp:  "Parse a URL and extract a Google Drive file ID and kind",
 		FlagSet:    fs,
 		Options: []ff.Option{
 			ff.WithEnvVarPrefix("GDOWN"),
@@ -337,6 +554,9 @@ func newParseUrlCommand() *ffcli.Command {
 			ff.WithConfigFileParser(ffyaml.Parser),
 		},
 		Exec: func(ctx context.Context, args []string) error {
+			if *scan {
+				return scanFiles(args)
+			}
 			if *urlFlag == "" {
 				return fmt.Errorf("flag -url is required")
 			}
@@ -345,7 +565,52 @@ func newParseUrlCommand() *ffcli.Command {
 				return err
 			}
 			fmt.Printf("File ID: %s\nIs Download Link: %v\n", fileId, isDownloadLink)
+			parsed, err := gdown.ClassifyUrl(*urlFlag)
+			if err != nil || parsed == nil {
+				return err
+			}
+			fmt.Printf("Kind: %s\n", parsed.Kind)
+			if parsed.ResourceKey != "" {
+				fmt.Printf("Resource Key: %s\n", parsed.ResourceKey)
+			}
+			if parsed.AccountIndex >= 0 {
+				fmt.Printf("Account Index: %d\n", parsed.AccountIndex)
+			}
 			return nil
 		},
 	}
 }
+
+// scanFiles prints the Drive links and IDs found in files, or in stdin if
+// no files (or "-") are given.
+func scanFiles(files []string) error {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var text strings.Builder
+	for _, name := range files {
+		var data []byte
+		var err error
+		if name == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(name)
+		}
+		if err != nil {
+			return err
+		}
+		text.Write(data)
+		text.WriteString("\n")
+	}
+	matches := gdown.ScanText(text.String())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKIND\tRESOURCE KEY\tSOURCE")
+	for _, m := range matches {
+		kind := string(m.Kind)
+		if kind == "" {
+			kind = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.ID, kind, m.ResourceKey, m.Text)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/igolaizola/gdown"
+	"github.com/peterbourgon/ff/v3"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/peterbourgon/ff/v3/ffyaml"
+)
+
+func newSyncFolderCommand() *ffcli.Command {
+	cmd := "syncfolder"
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	urlFlag := fs.String("url", "", "Folder URL (if empty, use -id)")
+	id := fs.String("id", "", "Folder ID (if -url is empty)")
+	output := fs.String("output", "", "Output directory")
+	deleteExtra := fs.Bool("delete", false, "Delete local files that are no longer in the folder")
+	quarantine := fs.String("quarantine", "", "Move local files that are no longer in the folder to this directory instead of deleting them")
+	quiet := fs.Bool("quiet", false, "Suppress logging")
+	proxy := fs.String("proxy", "", "Proxy URL")
+	speed := fs.Int64("speed", 0, "Download speed limit (bytes/sec)")
+	noCookies := fs.Bool("no-cookies", false, "Do not use cookies")
+	cookies := fs.String("cookies", "", "Netscape cookies.txt file to load cookies from (e.g. exported from a logged-in browser)")
+	saveCookies := fs.Bool("save-cookies", false, "Write updated cookies back to the -cookies file")
+	noVerify := fs.Bool("no-verify", false, "Do not verify TLS certificate")
+	userAgent := fs.String("user-agent", "", "User-Agent to use")
+	apiKey := fs.String("api-key", "", "Google Drive API v3 key; access Drive through the REST API instead of web pages")
+	credentials := fs.String("credentials", "", "Service account key or OAuth2 client ID JSON file to access private items")
+	remainingOk := fs.Bool("remaining-ok", false, "Allow folder contents to reach maximum limit")
+	skipShortcuts := fs.Bool("skip-shortcuts", false, "Leave out shortcuts instead of resolving them to their targets")
+	exportFormats := exportFormatsFlag(fs)
+	cacheDir := fs.String("cache-dir", "", "Cache directory for folder listings")
+	return &ffcli.Command{
+		Name:       cmd,
+		ShortUsage: fmt.Sprintf("gdown %s [flags]", cmd),
+		ShortHelp:  "Bring a local copy of a Google Drive folder up to date",
+		FlagSet:    fs,
+		Options: []ff.Option{
+			ff.WithEnvVarPrefix("GDOWN"),
+			ff.WithConfigFileFlag("config"),
+			ff.WithConfigFileParser(ffyaml.Parser),
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			if *urlFlag == "" && *id == "" {
+				return fmt.Errorf("either -url or -id must be specified")
+			}
+			if *deleteExtra && *quarantine != "" {
+				return fmt.Errorf("-delete and -quarantine can't be used together")
+			}
+			ts, err := tokenSource(*credentials, *cacheDir, *quiet)
+			if err != nil {
+				return err
+			}
+			opts := gdown.SyncOptions{
+				FolderOptions: gdown.FolderOptions{
+					DownloadOptions: gdown.DownloadOptions{
+						Quiet:       *quiet,
+						Proxy:       *proxy,
+						Speed:       *speed,
+						UseCookies:  !(*noCookies),
+						CookiesFile: *cookies,
+						SaveCookies: *saveCookies,
+						Verify:      !(*noVerify),
+						UserAgent:   *userAgent,
+						APIKey:      *apiKey,
+						TokenSource: ts,
+						CacheDir:    *cacheDir,
+					},
+					RemainingOk:   *remainingOk,
+					SkipShortcuts: *skipShortcuts,
+					ExportFormats: exportFormats,
+				},
+				Delete:     *deleteExtra,
+				Quarantine: *quarantine,
+			}
+			res, err := gdown.SyncFolder(*urlFlag, *id, *output, opts)
+			if res == nil {
+				return err
+			}
+			for _, c := range res.Changes {
+				if c.Action == gdown.SyncUnchanged {
+					continue
+				}
+				if c.Err != nil {
+					fmt.Printf("%-12s %s: %v\n", strings.ToUpper(string(c.Action)), c.Path, c.Err)
+					continue
+				}
+				fmt.Printf("%-12s %s\n", strings.ToUpper(string(c.Action)), c.Path)
+			}
+			fmt.Printf("Synced %s: %s\n", res.Root, res.Summary())
+			return err
+		},
+	}
+}
@@ -0,0 +1,209 @@
+package gdown
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Netscape cookies.txt import and persistence
+//
+
+// httpOnlyPrefix marks HttpOnly cookies in cookies.txt files written by
+// browsers and curl.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// cookieRecord is a cookie as stored in a cookies.txt file.
+type cookieRecord struct {
+	Domain   string // without the leading dot
+	HostOnly bool   // not sent to subdomains
+	Path     string
+	Secure   bool
+	HTTPOnly bool
+	Expires  int64 // unix time; 0 for session cookies
+	Name     string
+	Value    string
+}
+
+func (r cookieRecord) key() string {
+	return r.Domain + "\t" + r.Path + "\t" + r.Name
+}
+
+// cookie returns the record as an http.Cookie and the URL to set it for.
+func (r cookieRecord) cookie() (*url.URL, *http.Cookie) {
+	scheme := "http"
+	if r.Secure {
+		scheme = "https"
+	}
+	c := &http.Cookie{Name: r.Name, Value: r.Value, Path: r.Path, Secure: r.Secure, HttpOnly: r.HTTPOnly}
+	if !r.HostOnly {
+		c.Domain = r.Domain
+	}
+	if r.Expires > 0 {
+		c.Expires = time.Unix(r.Expires, 0)
+	}
+	return &url.URL{Scheme: scheme, Host: r.Domain, Path: r.Path}, c
+}
+
+// readCookiesFile parses a Netscape cookies.txt file.
+func readCookiesFile(p string) ([]cookieRecord, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []cookieRecord
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			// Cookies with an empty value may lose their trailing tab.
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%s:%d: expected 7 tab-separated fields", p, lineNo)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid expiry: %w", p, lineNo, err)
+		}
+		records = append(records, cookieRecord{
+			Domain:   strings.TrimPrefix(fields[0], "."),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HTTPOnly: httpOnly,
+			Expires:  expires,
+			Name:     fields[5],
+			Value:    fields[6],
+		})
+	}
+	return records, scanner.Err()
+}
+
+// writeCookiesFile writes records in the Netscape cookies.txt format.
+func writeCookiesFile(p string, records []cookieRecord) error {
+	sort.Slice(records, func(i, j int) bool { return records[i].key() < records[j].key() })
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n# Written by gdown. Edit at your own risk.\n\n")
+	bools := map[bool]string{true: "TRUE", false: "FALSE"}
+	for _, r := range records {
+		domain := r.Domain
+		if !r.HostOnly {
+			domain = "." + domain
+		}
+		if r.HTTPOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, bools[!r.HostOnly], r.Path, bools[r.Secure], r.Expires, r.Name, r.Value)
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// fileJar is a cookie jar seeded from a cookies.txt file that, when save is
+// set, writes the cookies back to it whenever a response updates them.
+type fileJar struct {
+	*cookiejar.Jar
+	path  string
+	save  bool
+	quiet bool
+
+	mu      sync.Mutex
+	records map[string]cookieRecord
+}
+
+// loadCookieJar returns a jar with the cookies of a cookies.txt file. A
+// missing file is only accepted when cookies are saved to it.
+func loadCookieJar(p string, save, quiet bool) (*fileJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	j := &fileJar{Jar: jar, path: p, save: save, quiet: quiet, records: map[string]cookieRecord{}}
+	records, err := readCookiesFile(p)
+	if errors.Is(err, os.ErrNotExist) && save {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read cookies: %w", err)
+	}
+	now := time.Now().Unix()
+	for _, r := range records {
+		if r.Expires > 0 && r.Expires < now {
+			continue
+		}
+		j.records[r.key()] = r
+		u, c := r.cookie()
+		jar.SetCookies(u, []*http.Cookie{c})
+	}
+	return j, nil
+}
+
+// SetCookies stores the cookies of a response and persists them if enabled.
+func (j *fileJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	if !j.save || len(cookies) == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		r := cookieRecord{Domain: strings.TrimPrefix(c.Domain, "."), Path: c.Path, Secure: c.Secure, HTTPOnly: c.HttpOnly, Name: c.Name, Value: c.Value}
+		if r.Domain == "" {
+			r.Domain, r.HostOnly = u.Hostname(), true
+		}
+		if r.Path == "" || !strings.HasPrefix(r.Path, "/") {
+			// The default path is the directory of the request path.
+			r.Path = path.Dir(u.Path)
+			if r.Path == "." {
+				r.Path = "/"
+			}
+		}
+		switch {
+		case c.MaxAge < 0:
+			r.Expires = -1
+		case c.MaxAge > 0:
+			r.Expires = now.Add(time.Duration(c.MaxAge) * time.Second).Unix()
+		case !c.Expires.IsZero():
+			r.Expires = c.Expires.Unix()
+		}
+		if r.Expires < 0 || (r.Expires > 0 && r.Expires <= now.Unix()) {
+			delete(j.records, r.key())
+			continue
+		}
+		j.records[r.key()] = r
+	}
+	records := make([]cookieRecord, 0, len(j.records))
+	for _, r := range j.records {
+		records = append(records, r)
+	}
+	if err := writeCookiesFile(j.path, records); err != nil && !j.quiet {
+		fmt.Fprintf(os.Stderr, "Couldn't save cookies: %v\n", err)
+	}
+}
@@ -0,0 +1,143 @@
+package gdown
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCookies writes the lines of a cookies.txt file into a temporary
+// directory and returns its path.
+func writeCookies(t *testing.T, lines ...string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := os.WriteFile(p, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReadCookiesFile(t *testing.T) {
+	p := writeCookies(t,
+		"# Netscape HTTP Cookie File",
+		"",
+		".example.com\tTRUE\t/\tTRUE\t2000000000\tdomain\tv1",
+		"host.example.com\tFALSE\t/path\tFALSE\t0\thost\tv2",
+		"#HttpOnly_.example.com\tTRUE\t/\tTRUE\t2000000000\tsession\tv3",
+		"example.com\tFALSE\t/\tFALSE\t0\tempty",
+	)
+	records, err := readCookiesFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cookieRecord{
+		{Domain: "example.com", Path: "/", Secure: true, Expires: 2000000000, Name: "domain", Value: "v1"},
+		{Domain: "host.example.com", HostOnly: true, Path: "/path", Name: "host", Value: "v2"},
+		{Domain: "example.com", Path: "/", Secure: true, HTTPOnly: true, Expires: 2000000000, Name: "session", Value: "v3"},
+		{Domain: "example.com", HostOnly: true, Path: "/", Name: "empty"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %+v, want %+v", records, want)
+	}
+
+	for _, line := range []string{"example.com\tFALSE\t/\tFALSE\t0", "example.com\tFALSE\t/\tFALSE\tnever\tname\tvalue"} {
+		if _, err := readCookiesFile(writeCookies(t, line)); err == nil {
+			t.Errorf("%q was accepted", line)
+		}
+	}
+}
+
+func TestCookiesFileRoundTrip(t *testing.T) {
+	p := writeCookies(t,
+		"#HttpOnly_.example.com\tTRUE\t/\tTRUE\t2000000000\tsession\tv1",
+		"host.example.com\tFALSE\t/path\tFALSE\t0\thost\t",
+	)
+	records, err := readCookiesFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.txt")
+	if err := writeCookiesFile(out, records); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "#HttpOnly_.example.com\tTRUE\t") {
+		t.Errorf("HttpOnly cookie written as:\n%s", data)
+	}
+	again, err := readCookiesFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, records) {
+		t.Errorf("round trip = %+v, want %+v", again, records)
+	}
+}
+
+func TestLoadCookieJar(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	p := writeCookies(t,
+		fmt.Sprintf(".example.com\tTRUE\t/\tFALSE\t%d\tdomain\tv1", future),
+		"example.com\tFALSE\t/\tFALSE\t0\thost\tv2",
+		"example.com\tFALSE\t/\tFALSE\t1000\texpired\tv3",
+	)
+	jar, err := loadCookieJar(p, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(rawURL string) []string {
+		u, _ := url.Parse(rawURL)
+		var names []string
+		for _, c := range jar.Cookies(u) {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+	if got := names("http://example.com/"); !reflect.DeepEqual(got, []string{"domain", "host"}) && !reflect.DeepEqual(got, []string{"host", "domain"}) {
+		t.Errorf("example.com cookies = %q", got)
+	}
+	if got := names("http://sub.example.com/"); !reflect.DeepEqual(got, []string{"domain"}) {
+		t.Errorf("sub.example.com cookies = %q, want only the domain cookie", got)
+	}
+
+	if _, err := loadCookieJar(filepath.Join(t.TempDir(), "missing.txt"), false, true); err == nil {
+		t.Error("missing cookies file accepted without saving")
+	}
+}
+
+func TestFileJarSavesCookies(t *testing.T) {
+	p := writeCookies(t,
+		"example.com\tFALSE\t/\tFALSE\t0\told\tv1",
+		"example.com\tFALSE\t/\tFALSE\t0\tkept\tv2",
+	)
+	jar, err := loadCookieJar(p, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://example.com/dir/page")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "old", Path: "/", MaxAge: -1},
+		{Name: "new", Value: "v3", HttpOnly: true},
+		{Name: "domain", Value: "v4", Domain: ".example.com", Path: "/", Secure: true},
+	})
+	records, err := readCookiesFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cookieRecord{
+		{Domain: "example.com", Path: "/", Secure: true, Name: "domain", Value: "v4"},
+		{Domain: "example.com", HostOnly: true, Path: "/", Name: "kept", Value: "v2"},
+		{Domain: "example.com", HostOnly: true, Path: "/dir", HTTPOnly: true, Name: "new", Value: "v3"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("saved records = %+v, want %+v", records, want)
+	}
+}
@@ -0,0 +1,267 @@
+package gdown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//
+// Drive API v3 backend (files.get, files.list and files.export)
+//
+
+// DriveAPIBaseURL is the base URL of the Drive v3 REST API. Like the other
+// base URLs it can be pointed at a local server in tests.
+var DriveAPIBaseURL = "https://www.googleapis.com/drive/v3"
+
+const (
+	folderMimeType   = "application/vnd.google-apps.folder"
+	shortcutMimeType = "application/vnd.google-apps.shortcut"
+)
+
+// nativeKinds maps the MIME types of Google-native items to their kind.
+var nativeKinds = map[string]URLKind{
+	"application/vnd.google-apps.document":     KindDocument,
+	"application/vnd.google-apps.spreadsheet":  KindSpreadsheet,
+	"application/vnd.google-apps.presentation": KindPresentation,
+	"application/vnd.google-apps.drawing":      KindDrawing,
+	"application/vnd.google-apps.form":         KindForm,
+}
+
+// exportMimeTypes maps export formats to the MIME types used by files.export.
+var exportMimeTypes = map[string]string{
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"odt":  "application/vnd.oasis.opendocument.text",
+	"ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	"odp":  "application/vnd.oasis.opendocument.presentation",
+	"pdf":  "application/pdf",
+	"rtf":  "application/rtf",
+	"txt":  "text/plain",
+	"md":   "text/markdown",
+	"html": "text/html",
+	"epub": "application/epub+zip",
+	"csv":  "text/csv",
+	"tsv":  "text/tab-separated-values",
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"svg":  "image/svg+xml",
+}
+
+// APIError is an error response of the Drive API.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("drive api: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// apiFile is the metadata requested from the Drive API.
+type apiFile struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	MimeType     string `json:"mimeType"`
+	ResourceKey  string `json:"resourceKey"`
+	DriveID      string `json:"driveId"`
+	Size         int64  `json:"size,string"`
+	ModifiedTime string `json:"modifiedTime"`
+	MD5Checksum  string `json:"md5Checksum"`
+	Owners       []struct {
+		DisplayName  string `json:"displayName"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"owners"`
+	ShortcutDetails struct {
+		TargetID          string `json:"targetId"`
+		TargetResourceKey string `json:"targetResourceKey"`
+	} `json:"shortcutDetails"`
+}
+
+const apiFileFields = "id,name,mimeType,resourceKey,driveId,size,modifiedTime,md5Checksum,owners(displayName,emailAddress),shortcutDetails"
+
+// driveAPI is a Drive v3 client authenticated with an API key or, through
+// the client's transport, with OAuth2 tokens.
+type driveAPI struct {
+	client    *http.Client
+	key       string
+	userAgent string
+}
+
+func newDriveAPI(client *http.Client, opts DownloadOptions) *driveAPI {
+	ua := opts.UserAgent
+	if ua == "" {
+		ua = "Mozilla/5.0 (compatible; gdown-go)"
+	}
+	return &driveAPI{client: client, key: opts.APIKey, userAgent: ua}
+}
+
+// get sends a GET request to the API. Resource keys of link-shared items
+// are sent as the API expects them; non-2xx responses become an *APIError.
+func (a *driveAPI) get(p string, query url.Values, id, resourceKey string, header http.Header) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if strings.HasPrefix(p, "/files") {
+		query.Set("supportsAllDrives", "true")
+	}
+	req, err := http.NewRequest("GET", DriveAPIBaseURL+p+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", a.userAgent)
+	if a.key != "" {
+		req.Header.Set("X-Goog-Api-Key", a.key)
+	}
+	if resourceKey != "" {
+		req.Header.Set(resourceKeysHeader, id+"/"+resourceKey)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		defer resp.Body.Close()
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		msg := resp.Status
+		if json.Unmarshal(data, &body) == nil && body.Error.Message != "" {
+			msg = body.Error.Message
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: msg}
+	}
+	return resp, nil
+}
+
+// getFile returns the metadata of an item (files.get).
+func (a *driveAPI) getFile(id, resourceKey string) (*apiFile, error) {
+	resp, err := a.get("/files/"+url.PathEscape(id), url.Values{"fields": {apiFileFields}}, id, resourceKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var f apiFile
+	if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+		return nil, fmt.Errorf("drive api: couldn't parse file metadata: %w", err)
+	}
+	return &f, nil
+}
+
+// driveName returns the name of a shared drive (drives.get).
+func (a *driveAPI) driveName(id string) (string, error) {
+	resp, err := a.get("/drives/"+url.PathEscape(id), url.Values{"fields": {"name"}}, id, "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var d struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return "", fmt.Errorf("drive api: couldn't parse drive metadata: %w", err)
+	}
+	return d.Name, nil
+}
+
+// listChildren returns every item in a folder, following pageToken
+// (files.list). Items of shared drives are only listed from the drive's
+// corpus, so driveID is needed for them.
+func (a *driveAPI) listChildren(id, resourceKey, driveID string) ([]apiFile, error) {
+	var files []apiFile
+	pageToken := ""
+	for {
+		query := url.Values{
+			"q":                         {fmt.Sprintf("'%s' in parents and trashed = false", strings.ReplaceAll(id, "'", `\'`))},
+			"fields":                    {"nextPageToken,files(" + apiFileFields + ")"},
+			"pageSize":                  {"1000"},
+			"orderBy":                   {"folder,name"},
+			"includeItemsFromAllDrives": {"true"},
+		}
+		if driveID != "" {
+			query.Set("corpora", "drive")
+			query.Set("driveId", driveID)
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		resp, err := a.get("/files", query, id, resourceKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			NextPageToken string    `json:"nextPageToken"`
+			Files         []apiFile `json:"files"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("drive api: couldn't parse file list: %w", err)
+		}
+		files = append(files, page.Files...)
+		if page.NextPageToken == "" {
+			return files, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// media returns the content of a binary file from offset on
+// (files.get?alt=media), or NotModified content if cond still matches.
+func (a *driveAPI) media(id, resourceKey string, offset int64, cond *Validators) (*Content, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if cond != nil {
+		if cond.ETag != "" {
+			header.Set("If-None-Match", cond.ETag)
+		}
+		if cond.LastModified != "" {
+			header.Set("If-Modified-Since", cond.LastModified)
+		}
+	}
+	source := DriveAPIBaseURL + "/files/" + id
+	resp, err := a.get("/files/"+url.PathEscape(id), url.Values{"alt": {"media"}}, id, resourceKey, header)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// The file is already complete.
+		return &Content{ReadCloser: http.NoBody, Offset: offset, Source: source}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Content{ReadCloser: resp.Body, Header: resp.Header, Source: source}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		c.Offset = offset
+	case http.StatusNotModified:
+		c.NotModified = true
+	}
+	return c, nil
+}
+
+// export returns a Google-native item converted to format (files.export).
+func (a *driveAPI) export(id, resourceKey, format string) (*Content, error) {
+	mimeType, ok := exportMimeTypes[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+	resp, err := a.get("/files/"+url.PathEscape(id)+"/export", url.Values{"mimeType": {mimeType}}, id, resourceKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Content{ReadCloser: resp.Body, Header: resp.Header, Source: DriveAPIBaseURL + "/files/" + id}, nil
+}
@@ -0,0 +1,72 @@
+package gdown_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/igolaizola/gdown"
+	"github.com/igolaizola/gdown/gdowntest"
+)
+
+func TestDownloadFolderScrapedSizeIsNotEnforced(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	defer srv.Install()()
+	srv.AddFolder("", "root", "root")
+	file := srv.AddFile("root", "file", "a.txt", []byte("hello"))
+	file.ListedSize = 1000 // a stale folder page
+
+	opts := gdown.FolderOptions{DownloadOptions: gdown.DownloadOptions{Quiet: true, CacheDir: t.TempDir()}}
+	infos, err := gdown.ListFolder("", "root", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Size != 1000 || infos[0].SizeVerified {
+		t.Fatalf("listing = %+v, want the scraped, unverified size", infos)
+	}
+
+	dir := t.TempDir()
+	if _, err := gdown.DownloadFolder("", "root", dir+string(os.PathSeparator), opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "root", "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("a.txt holds %q, want %q", data, "hello")
+	}
+
+	opts.APIKey = "test-key"
+	infos, err = gdown.ListFolder("", "root", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Size != 5 || !infos[0].SizeVerified {
+		t.Fatalf("api listing = %+v, want the verified size", infos)
+	}
+}
+
+func TestDownloadFolder(t *testing.T) {
+	for name, base := range backends {
+		t.Run(name, func(t *testing.T) {
+			newServer(t)
+			dir := t.TempDir()
+			opts := gdown.FolderOptions{DownloadOptions: options(t, base)}
+			if _, err := gdown.DownloadFolder("", "root", dir+string(os.PathSeparator), opts); err != nil {
+				t.Fatal(err)
+			}
+			for p, want := range map[string]string{
+				"a.txt":      "alpha",
+				"sub/b.txt":  "bravo",
+				"notes.docx": "docx data",
+				"link.txt":   "target",
+			} {
+				if got := readFile(t, filepath.Join(dir, "root", filepath.FromSlash(p))); got != want {
+					t.Errorf("%s holds %q, want %q", p, got, want)
+				}
+			}
+		})
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"archive/zip"
 	"compress/gzip"
 	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"html"
 	"io"
 	"net/http"
@@ -20,6 +23,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -45,20 +49,48 @@ var (
 //
 
 type DownloadOptions struct {
-	Quiet      bool
-	Proxy      string
-	Speed      int64 // bytes per second; 0 means unlimited
-	UseCookies bool
-	Verify     bool
-	Resume     bool
-	Fuzzy      bool
-	Format     string
-	UserAgent  string
+	Quiet        bool
+	Proxy        string
+	Speed        int64 // bytes per second; 0 means unlimited
+	UseCookies   bool
+	Verify       bool
+	Resume       bool
+	Fuzzy        bool
+	Format       string
+	UserAgent    string
+	CacheDir     string      // cache directory; empty means the default (see CacheDir)
+	ReadOnlyDirs []string    // read-only caches consulted before downloading (e.g. a team mirror)
+	Offline      bool        // never touch the network; serve from cache or fail (also GDOWN_OFFLINE)
+	ExpectedSize int64       // expected file size; the output is preallocated and the download checked against it
+	APIKey       string      // Drive API v3 key; when set, Drive items are accessed through the REST API instead of web pages
+	TokenSource  TokenSource // OAuth2 or service account credentials for private items; implies the Drive API
+	CookiesFile  string      // Netscape cookies.txt seeding the cookie jar (e.g. a logged-in browser session)
+	SaveCookies  bool        // write cookies updated by responses back to CookiesFile
+	Backend      Backend     // lists and reads Drive items instead of the scraper or the Drive API (e.g. a fixture in tests)
+}
+
+// useAPI reports whether Drive items are accessed through the Drive API.
+func (o DownloadOptions) useAPI() bool {
+	return o.APIKey != "" || o.TokenSource != nil
 }
 
 type FolderOptions struct {
 	DownloadOptions
-	RemainingOk bool
+	RemainingOk   bool
+	SkipShortcuts bool               // leave shortcuts out instead of resolving them to their targets
+	ExportFormats map[URLKind]string // export format of Google-native items by kind; Format or the kind's default otherwise
+}
+
+// exportFormat returns the format Google-native items of kind are exported
+// in when downloading a folder.
+func (o FolderOptions) exportFormat(kind URLKind) string {
+	if f := o.ExportFormats[kind]; f != "" {
+		return f
+	}
+	if o.Format != "" {
+		return o.Format
+	}
+	return defaultExportFormats[kind]
 }
 
 //
@@ -108,13 +140,49 @@ func sanitizeFilename(name string) string {
 	return strings.ReplaceAll(name, string(os.PathSeparator), "_")
 }
 
-// getCacheRoot returns the cache directory for downloads.
-func getCacheRoot() string {
-	usr, err := user.Current()
-	if err != nil {
-		return "."
+// getCacheRoot returns the cache directory for downloads. An explicit dir
+// takes precedence, then GDOWN_CACHE_DIR, then $XDG_CACHE_HOME/gdown and
+// finally ~/.cache/gdown. Without a home directory (e.g. containers with no
+// passwd entry) the system temporary directory is used.
+func getCacheRoot(dir string) string {
+	if dir != "" {
+		return dir
+	}
+	if env := os.Getenv("GDOWN_CACHE_DIR"); env != "" {
+		return env
+	}
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "gdown")
+	}
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		return filepath.Join(home, ".cache", "gdown")
 	}
-	return filepath.Join(usr.HomeDir, ".cache", "gdown")
+	if usr, err := user.Current(); err == nil && usr.HomeDir != "" {
+		return filepath.Join(usr.HomeDir, ".cache", "gdown")
+	}
+	return filepath.Join(os.TempDir(), "gdown")
+}
+
+// CacheDir returns the cache directory that would be used for dir, applying
+// the same precedence as CachedDownload.
+func CacheDir(dir string) string {
+	return getCacheRoot(dir)
+}
+
+// getReadOnlyCacheDirs returns the read-only cache directories to consult
+// before downloading: dirs if given, otherwise the GDOWN_CACHE_READONLY_DIRS
+// list (separated by the OS path list separator).
+func getReadOnlyCacheDirs(dirs []string) []string {
+	if len(dirs) > 0 {
+		return dirs
+	}
+	var result []string
+	for _, d := range filepath.SplitList(os.Getenv("GDOWN_CACHE_READONLY_DIRS")) {
+		if d != "" {
+			result = append(result, d)
+		}
+	}
+	return result
 }
 
 //
@@ -135,7 +203,13 @@ func newHTTPClient(opts DownloadOptions) (*http.Client, error) {
 		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 	var jar http.CookieJar
-	if opts.UseCookies {
+	if opts.CookiesFile != "" {
+		fj, err := loadCookieJar(opts.CookiesFile, opts.SaveCookies, opts.Quiet)
+		if err != nil {
+			return nil, err
+		}
+		jar = fj
+	} else if opts.UseCookies {
 		var err error
 		jar, err = cookiejar.New(nil)
 		if err != nil {
@@ -147,44 +221,89 @@ func newHTTPClient(opts DownloadOptions) (*http.Client, error) {
 		Jar:       jar,
 		Timeout:   0,
 	}
+	if opts.TokenSource != nil {
+		client.Transport = &authTransport{source: opts.TokenSource, base: transport}
+	}
 	return client, nil
 }
 
 //
-// MD5 hash functions (from cached_download.py)
+// Hash functions (from cached_download.py)
 //
 
 func MD5Sum(filename string) (string, error) {
+	return fileSum(filename, "md5")
+}
+
+// newHash returns a hash.Hash for the given algorithm name.
+func newHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", algo)
+	}
+}
+
+// fileSum returns the hex digest of filename using the given algorithm.
+func fileSum(filename, algo string) (string, error) {
+	h, err := newHash(algo)
+	if err != nil {
+		return "", err
+	}
 	f, err := os.Open(filename)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, f); err != nil {
+	if _, err := io.Copy(h, f); err != nil {
 		return "", err
 	}
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func assertFileHash(filename, expectedHash string, quiet bool) (bool, error) {
+// fileHashes computes the md5 and sha256 digests of filename in a single pass.
+func fileHashes(filename string) (map[string]string, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	md5Hash := md5.New()
+	sha256Hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), f)
+	if err != nil {
+		return nil, 0, err
+	}
+	return map[string]string{
+		"md5":    hex.EncodeToString(md5Hash.Sum(nil)),
+		"sha256": hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, n, nil
+}
+
+// splitHash splits an "<algo>:<hex>" hash specification.
+func splitHash(expectedHash string) (string, string, error) {
 	parts := strings.SplitN(expectedHash, ":", 2)
 	if len(parts) != 2 {
-		return false, fmt.Errorf("invalid hash format: %s", expectedHash)
+		return "", "", fmt.Errorf("invalid hash format: %s", expectedHash)
 	}
-	algo := parts[0]
-	expected := parts[1]
-	var actual string
-	switch algo {
-	case "md5":
-		sum, err := MD5Sum(filename)
-		if err != nil {
-			return false, err
-		}
-		actual = sum
-	default:
-		return false, fmt.Errorf("unsupported hash algorithm: %s", algo)
+	return parts[0], strings.ToLower(parts[1]), nil
+}
+
+func assertFileHash(filename, expectedHash string, quiet bool) (bool, error) {
+	algo, expected, err := splitHash(expectedHash)
+	if err != nil {
+		return false, err
+	}
+	actual, err := fileSum(filename, algo)
+	if err != nil {
+		return false, err
 	}
 	if actual == expected {
 		if !quiet {
@@ -200,33 +319,117 @@ func assertFileHash(filename, expectedHash string, quiet bool) (bool, error) {
 //
 
 func Download(urlStr, output string, opts DownloadOptions) (string, error) {
+	if isOffline(opts) {
+		return offlineDownload(urlStr, output, opts)
+	}
+	res, err := download(urlStr, output, opts, nil)
+	if err != nil {
+		return "", err
+	}
+	return res.Path, nil
+}
+
+// Validators are the ETag and Last-Modified of a cached copy, sent with a
+// request to revalidate it.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// downloadResult describes the outcome of a single download.
+type downloadResult struct {
+	Path        string
+	Header      http.Header
+	NotModified bool // server answered 304 to a conditional request
+}
+
+func download(urlStr, output string, opts DownloadOptions, cond *Validators) (*downloadResult, error) {
+	client, err := newHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return downloadWithClient(client, urlStr, output, opts, cond)
+}
+
+// downloadWithClient is download using an existing client, so that
+// several downloads can share connections and cookies.
+func downloadWithClient(client *http.Client, urlStr, output string, opts DownloadOptions, cond *Validators) (*downloadResult, error) {
 	if opts.UserAgent == "" {
 		opts.UserAgent = "Mozilla/5.0 (compatible; gdown-go)"
 	}
-	client, err := newHTTPClient(opts)
+
+	// Link-shared items may need their resource key on every request.
+	var resourceKey string
+	if parsed, err := ClassifyUrl(urlStr); err == nil && parsed != nil && parsed.ID != "" {
+		if opts.useBackend() && parsed.Kind != KindFolder && parsed.Kind != KindForm {
+			return backendDownload(newBackend(client, opts), parsed, output, opts, cond)
+		}
+		if parsed.ResourceKey != "" {
+			resourceKey = parsed.ID + "/" + parsed.ResourceKey
+		}
+	}
+
+	var startSize int64
+	if opts.Resume && fileExists(output) {
+		if fi, err := os.Stat(output); err == nil {
+			startSize = fi.Size()
+		}
+	}
+	resp, urlStr, err := openURL(client, urlStr, resourceKey, startSize, opts, cond)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if resp == nil {
+		return &downloadResult{Path: output}, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return &downloadResult{Path: output, Header: resp.Header, NotModified: true}, nil
+	}
+	// If output is empty, use the basename from the URL.
+	if output == "" {
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return nil, err
+		}
+		output = path.Base(u.Path)
 	}
+	return saveResponse(resp, urlStr, output, startSize, opts)
+}
 
+// openURL requests urlStr from offset on, following Drive's confirmation
+// pages, and returns the response with the URL that served it. resourceKey
+// is the "<id>/<key>" value sent to Drive. The response is nil if the
+// confirmation page links back to itself, and has status 304 when cond
+// still matches.
+func openURL(client *http.Client, urlStr, resourceKey string, offset int64, opts DownloadOptions, cond *Validators) (*http.Response, string, error) {
 	origUrl := urlStr
 	for {
-		var startSize int64 = 0
-		if opts.Resume && fileExists(output) {
-			if fi, err := os.Stat(output); err == nil {
-				startSize = fi.Size()
-			}
-		}
 		req, err := http.NewRequest("GET", urlStr, nil)
 		if err != nil {
-			return "", err
+			return nil, urlStr, err
 		}
 		req.Header.Set("User-Agent", opts.UserAgent)
-		if startSize > 0 {
-			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", startSize))
+		if resourceKey != "" && IsGoogleDriveUrl(urlStr) {
+			req.Header.Set(resourceKeysHeader, resourceKey)
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		if cond != nil {
+			if cond.ETag != "" {
+				req.Header.Set("If-None-Match", cond.ETag)
+			}
+			if cond.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cond.LastModified)
+			}
 		}
 		resp, err := client.Do(req)
 		if err != nil {
-			return "", err
+			return nil, urlStr, err
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			return resp, urlStr, nil
 		}
 
 		// If HTML, try to extract a confirmation download URL.
@@ -235,64 +438,77 @@ func Download(urlStr, output string, opts DownloadOptions) (string, error) {
 			bodyBytes, err := io.ReadAll(resp.Body)
 			resp.Body.Close()
 			if err != nil {
-				return "", err
+				return nil, urlStr, err
 			}
 			newUrl, err := getUrlFromGDriveConfirmation(string(bodyBytes))
 			if err != nil {
-				return "", err
+				return nil, urlStr, err
 			}
 			urlStr = newUrl
 			if origUrl == urlStr {
-				break
+				return nil, urlStr, nil
 			}
 			continue
 		}
 
-		defer resp.Body.Close()
 		if resp.StatusCode >= 400 {
-			return "", fmt.Errorf("HTTP error: %s", resp.Status)
+			resp.Body.Close()
+			return nil, urlStr, fmt.Errorf("HTTP error: %s", resp.Status)
 		}
+		return resp, urlStr, nil
+	}
+}
 
-		// If output is empty, use the basename from the URL.
-		if output == "" {
-			u, err := url.Parse(urlStr)
-			if err != nil {
-				return "", err
-			}
-			output = path.Base(u.Path)
-		}
-		// If output is a directory, get filename from response.
-		if fi, err := os.Stat(output); err == nil && fi.IsDir() {
-			fname := getFilenameFromResponse(resp)
-			output = filepath.Join(output, fname)
-		}
-		// Open file (append if resuming).
-		var file *os.File
-		if opts.Resume {
-			file, err = os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		} else {
-			file, err = os.Create(output)
-		}
-		if err != nil {
-			return "", err
+// saveResponse writes the body of a successful response to output (a file,
+// or a directory taking the file name sent by the server). startSize is the
+// size already on disk when resuming.
+func saveResponse(resp *http.Response, urlStr, output string, startSize int64, opts DownloadOptions) (*downloadResult, error) {
+	// If output is a directory, get filename from response.
+	if fi, err := os.Stat(output); err == nil && fi.IsDir() {
+		fname := getFilenameFromResponse(resp)
+		output = filepath.Join(output, fname)
+	}
+	// Open file (append if resuming).
+	var file *os.File
+	var err error
+	if opts.Resume {
+		file, err = os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	} else {
+		file, err = os.Create(output)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	// Reserve the expected size up front; appended (resumed) files
+	// can't be preallocated.
+	prealloc := opts.ExpectedSize > 0 && !opts.Resume
+	if prealloc {
+		if err := file.Truncate(opts.ExpectedSize); err != nil {
+			return nil, err
 		}
-		defer file.Close()
+	}
 
-		var writer io.Writer = file
-		if opts.Speed > 0 {
-			writer = NewThrottledWriter(file, opts.Speed)
-		}
-		if !opts.Quiet {
-			fmt.Fprintf(os.Stderr, "Downloading %s to %s\n", urlStr, output)
-		}
-		buf := make([]byte, CHUNK_SIZE)
-		_, err = io.CopyBuffer(writer, resp.Body, buf)
-		if err != nil {
-			return "", err
-		}
-		return output, nil
+	var writer io.Writer = file
+	if opts.Speed > 0 {
+		writer = NewThrottledWriter(file, opts.Speed)
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Downloading %s to %s\n", urlStr, output)
 	}
-	return output, nil
+	buf := make([]byte, CHUNK_SIZE)
+	n, err := io.CopyBuffer(writer, resp.Body, buf)
+	if prealloc && n != opts.ExpectedSize {
+		// Drop the unwritten tail so that a resume continues from n.
+		_ = file.Truncate(n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.ExpectedSize > 0 && startSize+n != opts.ExpectedSize {
+		return nil, fmt.Errorf("size mismatch for file %s: got %d bytes, expected %d", output, startSize+n, opts.ExpectedSize)
+	}
+	return &downloadResult{Path: output, Header: resp.Header}, nil
 }
 
 // getFilenameFromResponse extracts a filename from the Content-Disposition header.
@@ -321,7 +537,7 @@ func getUrlFromGDriveConfirmation(html string) (string, error) {
 	re := regexp.MustCompile(`href="(\/uc\?export=download[^"]+)"`)
 	matches := re.FindStringSubmatch(html)
 	if len(matches) == 2 {
-		urlStr := "https://docs.google.com" + matches[1]
+		urlStr := DocsBaseURL + matches[1]
 		urlStr = strings.ReplaceAll(urlStr, "&amp;", "&")
 		return urlStr, nil
 	}
@@ -333,125 +549,107 @@ func getUrlFromGDriveConfirmation(html string) (string, error) {
 //
 
 func CachedDownload(urlStr, outputPath, hash string, quiet bool, postprocess func(string) error, opts DownloadOptions) (string, error) {
-	cacheRoot := getCacheRoot()
-	_ = os.MkdirAll(cacheRoot, os.ModePerm)
-	if outputPath == "" {
-		// Sanitize the URL to use as a filename.
-		sanitized := strings.NewReplacer("/", "-SLASH-", ":", "-COLON-", "=", "-EQUAL-", "?", "-QUESTION-").Replace(urlStr)
-		outputPath = filepath.Join(cacheRoot, sanitized)
-	}
-	if fileExists(outputPath) && hash == "" {
-		if !quiet {
-			fmt.Fprintf(os.Stderr, "File exists: %s\n", outputPath)
-		}
-		return outputPath, nil
-	} else if fileExists(outputPath) && hash != "" {
-		if ok, _ := assertFileHash(outputPath, hash, quiet); ok {
-			return outputPath, nil
-		}
-		fmt.Fprintf(os.Stderr, "Hash mismatch, redownloading: %s\n", outputPath)
-	}
-	tmpDir, err := os.MkdirTemp(cacheRoot, "dl")
-	if err != nil {
-		return "", err
+	copts := CacheOptions{DownloadOptions: opts}
+	if quiet {
+		copts.Quiet = true
 	}
-	defer os.RemoveAll(tmpDir)
-	tempPath := filepath.Join(tmpDir, "dl")
-	downloadedPath, err := Download(urlStr, tempPath, opts)
-	if err != nil {
-		return "", err
+	var pp *Postprocessor
+	if postprocess != nil {
+		pp = &Postprocessor{Func: postprocess}
 	}
-	err = os.Rename(downloadedPath, outputPath)
+	res, err := CachedDownloadWithOptions(urlStr, outputPath, hash, pp, copts)
 	if err != nil {
 		return "", err
 	}
-	if hash != "" {
-		if ok, err := assertFileHash(outputPath, hash, quiet); err != nil || !ok {
-			return "", fmt.Errorf("hash mismatch for file %s", outputPath)
-		}
-	}
-	if postprocess != nil {
-		if err := postprocess(outputPath); err != nil {
-			return "", err
-		}
-	}
-	return outputPath, nil
+	return res.Path, nil
 }
 
 //
-// URL parsing helpers (from parse_url.py)
+// Archive extraction (from extractall.py)
 //
 
-func IsGoogleDriveUrl(urlStr string) bool {
-	u, err := url.Parse(urlStr)
+// archiveFormat returns "zip", "tar" or "tar.gz" for archivePath, using its
+// extension or, failing that, its leading bytes (cached files are named
+// after their URL and usually have no extension).
+func archiveFormat(archivePath string) string {
+	switch {
+	case strings.HasSuffix(archivePath, ".zip"):
+		return "zip"
+	case strings.HasSuffix(archivePath, ".tar"):
+		return "tar"
+	case strings.HasSuffix(archivePath, ".tar.gz"), strings.HasSuffix(archivePath, ".tgz"):
+		return "tar.gz"
+	}
+	f, err := os.Open(archivePath)
 	if err != nil {
-		return false
-	}
-	host := u.Hostname()
-	return host == "drive.google.com" || host == "docs.google.com"
-}
-
-// ParseUrl extracts a Google Drive file ID (if any) from the URL.
-func ParseUrl(urlStr string, warn bool) (fileId string, isDownloadLink bool, err error) {
-	parsed, err := url.Parse(urlStr)
-	if err != nil {
-		return "", false, err
+		return ""
 	}
-	queryParams := parsed.Query()
-	isGDrive := IsGoogleDriveUrl(urlStr)
-	isDownloadLink = strings.HasSuffix(parsed.Path, "/uc")
-	if !isGDrive {
-		return "", false, nil
-	}
-	if queryParams.Has("id") {
-		fileId = queryParams.Get("id")
-		return fileId, isDownloadLink, nil
-	}
-	patterns := []string{
-		`^/file/d/(.*?)/(edit|view)$`,
-		`^/file/u/[0-9]+/d/(.*?)/(edit|view)$`,
-		`^/document/d/(.*?)/(edit|htmlview|view)$`,
-		`^/document/u/[0-9]+/d/(.*?)/(edit|htmlview|view)$`,
-		`^/presentation/d/(.*?)/(edit|htmlview|view)$`,
-		`^/presentation/u/[0-9]+/d/(.*?)/(edit|htmlview|view)$`,
-		`^/spreadsheets/d/(.*?)/(edit|htmlview|view)$`,
-		`^/spreadsheets/u/[0-9]+/d/(.*?)/(edit|htmlview|view)$`,
-	}
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		matches := re.FindStringSubmatch(parsed.Path)
-		if len(matches) >= 2 {
-			fileId = matches[1]
-			break
+	defer f.Close()
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+	switch {
+	case len(header) >= 4 && string(header[:4]) == "PK\x03\x04":
+		return "zip"
+	case len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b:
+		// Only a gzipped tar is an archive; other gzip files are left alone.
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return ""
 		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return ""
+		}
+		defer gz.Close()
+		block := make([]byte, 512)
+		n, _ := io.ReadFull(gz, block)
+		if isTarHeader(block[:n]) {
+			return "tar.gz"
+		}
+	case isTarHeader(header):
+		return "tar"
 	}
-	if warn && fileId != "" && !isDownloadLink {
-		fmt.Fprintln(os.Stderr, "Warning: You specified a Google Drive link that is not a direct download link. Consider using fuzzy matching.")
-	}
-	return fileId, isDownloadLink, nil
+	return ""
 }
 
-//
-// Archive extraction (from extractall.py)
-//
+// isTarHeader reports whether block starts with a ustar (POSIX or GNU) tar
+// header.
+func isTarHeader(block []byte) bool {
+	return len(block) >= 262 && string(block[257:262]) == "ustar"
+}
 
 func ExtractAll(archivePath, to string) ([]string, error) {
 	if to == "" {
 		to = filepath.Dir(archivePath)
 	}
+	format := archiveFormat(archivePath)
 	var extractedFiles []string
-	if strings.HasSuffix(archivePath, ".zip") {
+	if format == "zip" {
 		r, err := zip.OpenReader(archivePath)
 		if err != nil {
 			return nil, err
 		}
 		defer r.Close()
 		for _, f := range r.File {
-			fpath := filepath.Join(to, f.Name)
+			fpath, err := extractPath(to, f.Name)
+			if err != nil {
+				return nil, err
+			}
 			if f.FileInfo().IsDir() {
 				_ = os.MkdirAll(fpath, os.ModePerm)
 				continue
 			}
+			if f.Mode()&os.ModeSymlink != 0 {
+				target, err := readZipLink(f)
+				if err != nil {
+					return nil, err
+				}
+				if err := extractSymlink(to, fpath, target); err != nil {
+					return nil, err
+				}
+				extractedFiles = append(extractedFiles, fpath)
+				continue
+			}
 			_ = os.MkdirAll(filepath.Dir(fpath), os.ModePerm)
 			outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
 			if err != nil {
@@ -471,16 +669,14 @@ func ExtractAll(archivePath, to string) ([]string, error) {
 			extractedFiles = append(extractedFiles, fpath)
 		}
 		return extractedFiles, nil
-	} else if strings.HasSuffix(archivePath, ".tar") ||
-		strings.HasSuffix(archivePath, ".tar.gz") ||
-		strings.HasSuffix(archivePath, ".tgz") {
+	} else if format == "tar" || format == "tar.gz" {
 		f, err := os.Open(archivePath)
 		if err != nil {
 			return nil, err
 		}
 		defer f.Close()
 		var tarReader *tar.Reader
-		if strings.HasSuffix(archivePath, ".tar") {
+		if format == "tar" {
 			tarReader = tar.NewReader(f)
 		} else {
 			gz, err := gzip.NewReader(f)
@@ -498,10 +694,18 @@ func ExtractAll(archivePath, to string) ([]string, error) {
 			if err != nil {
 				return nil, err
 			}
-			fpath := filepath.Join(to, header.Name)
+			fpath, err := extractPath(to, header.Name)
+			if err != nil {
+				return nil, err
+			}
 			switch header.Typeflag {
 			case tar.TypeDir:
 				_ = os.MkdirAll(fpath, os.ModePerm)
+			case tar.TypeSymlink:
+				if err := extractSymlink(to, fpath, header.Linkname); err != nil {
+					return nil, err
+				}
+				extractedFiles = append(extractedFiles, fpath)
 			case tar.TypeReg:
 				_ = os.MkdirAll(filepath.Dir(fpath), os.ModePerm)
 				outFile, err := os.Create(fpath)
@@ -522,22 +726,68 @@ func ExtractAll(archivePath, to string) ([]string, error) {
 	}
 }
 
+// extractPath returns where the archive entry name is extracted to, refusing
+// names that would land outside to (zip-slip).
+func extractPath(to, name string) (string, error) {
+	p := filepath.Join(to, name)
+	if p != filepath.Clean(to) && !isWithin(to, p) {
+		return "", fmt.Errorf("archive entry %s is outside %s", name, to)
+	}
+	return p, nil
+}
+
+// extractSymlink creates the symlink p pointing at target, which has to be
+// a relative path that stays within to.
+func extractSymlink(to, p, target string) error {
+	if filepath.IsAbs(target) || !isWithin(to, filepath.Join(filepath.Dir(p), target)) {
+		return fmt.Errorf("archive entry %s links outside %s: %s", p, to, target)
+	}
+	_ = os.MkdirAll(filepath.Dir(p), os.ModePerm)
+	_ = os.Remove(p)
+	return os.Symlink(target, p)
+}
+
+// readZipLink returns the target of a symlink stored in a zip archive.
+func readZipLink(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(rc)
+	return string(target), err
+}
+
 //
 // Google Drive folder download support (from download_folder.py)
 //
 
 // GoogleDriveFile represents a file or folder on Google Drive.
 type GoogleDriveFile struct {
-	ID       string
-	Name     string
-	Type     string
-	Children []*GoogleDriveFile
+	ID                string
+	Name              string
+	Type              string
+	ResourceKey       string    // required to access some link-shared items
+	DriveID           string    // shared drive holding the item, if known
+	TargetID          string    // target of a shortcut, if known
+	TargetResourceKey string    // resource key of the shortcut target
+	Size              int64     // size in bytes of binary files; 0 when unknown
+	SizeVerified      bool      // Size comes from the Drive API, not a scraped page, and downloads are checked against it
+	ModifiedTime      time.Time // zero when unknown
+	MD5Checksum       string    // hex MD5 of binary files, if known
+	Owner             string    // email or name of the owner, if known
+	Children          []*GoogleDriveFile
 }
 
 func (f *GoogleDriveFile) IsFolder() bool {
 	return f.Type == "application/vnd.google-apps.folder"
 }
 
+// IsShortcut reports whether the item is a shortcut to another file or folder.
+func (f *GoogleDriveFile) IsShortcut() bool {
+	return f.Type == shortcutMimeType
+}
+
 // decodeUnicodeEscapes converts any \xHH sequences into \u00HH sequences
 // and then uses json.Unmarshal to decode the resulting JSON string.
 func decodeUnicodeEscapes(s string) (string, error) {
@@ -554,11 +804,7 @@ func decodeUnicodeEscapes(s string) (string, error) {
 }
 
 // parseGoogleDriveFile parses HTML content to extract folder information.
-func parseGoogleDriveFile(urlStr, content string) (*GoogleDriveFile, []struct {
-	ID   string
-	Name string
-	Type string
-}, error) {
+func parseGoogleDriveFile(urlStr, content string) (*GoogleDriveFile, []*GoogleDriveFile, error) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
 	if err != nil {
 		return nil, nil, err
@@ -609,89 +855,147 @@ func parseGoogleDriveFile(urlStr, content string) (*GoogleDriveFile, []struct {
 		Name: name,
 		Type: "application/vnd.google-apps.folder",
 	}
-	var children []struct {
-		ID   string
-		Name string
-		Type string
+	if parsed, err := ClassifyUrl(urlStr); err == nil && parsed != nil {
+		gfile.ID = parsed.ID
+		gfile.ResourceKey = parsed.ResourceKey
 	}
+	var children []*GoogleDriveFile
 	for _, item := range folderContents {
 		if arr, ok := item.([]interface{}); ok && len(arr) >= 4 {
 			id, _ := arr[0].(string)
 			nameEncoded, _ := arr[2].(string)
 			typ, _ := arr[3].(string)
-			children = append(children, struct {
-				ID   string
-				Name string
-				Type string
-			}{ID: id, Name: nameEncoded, Type: typ})
+			child := &GoogleDriveFile{ID: id, Name: nameEncoded, Type: typ, ResourceKey: findResourceKey(arr[4:])}
+			if ms, ok := entryNumber(arr, entryModifiedTime); ok && ms > 0 {
+				child.ModifiedTime = time.UnixMilli(ms).UTC()
+			}
+			if size, ok := entryNumber(arr, entrySize); ok && size > 0 && typ != folderMimeType {
+				child.Size = size
+			}
+			children = append(children, child)
 		}
 	}
 	return gfile, children, nil
 }
 
-// downloadAndParseGoogleDriveLink retrieves and parses a folder page.
-func downloadAndParseGoogleDriveLink(client *http.Client, urlStr string, quiet bool, remainingOk, verify bool) (*GoogleDriveFile, error) {
-	if IsGoogleDriveUrl(urlStr) {
-		if strings.Contains(urlStr, "?") {
-			urlStr += "&hl=en"
-		} else {
-			urlStr += "?hl=en"
+// Positions of optional fields in folder page entries. They aren't
+// documented, so they are only used when they hold numbers.
+const (
+	entryModifiedTime = 9  // milliseconds since the epoch
+	entrySize         = 13 // bytes
+)
+
+// entryNumber returns field i of a folder page entry if it is a number
+// (possibly sent as a string).
+func entryNumber(entry []interface{}, i int) (int64, bool) {
+	if i >= len(entry) {
+		return 0, false
+	}
+	switch v := entry[i].(type) {
+	case float64:
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// resourceKeyRe matches the resource keys of link-shared items.
+var resourceKeyRe = regexp.MustCompile(`^0-[A-Za-z0-9_-]{10,}$`)
+
+// findResourceKey returns the first value of a folder entry that looks like
+// a resource key. The position of the key in the entry isn't stable, so the
+// remaining fields are scanned.
+func findResourceKey(fields []interface{}) string {
+	for _, field := range fields {
+		if s, ok := field.(string); ok && resourceKeyRe.MatchString(s) {
+			return s
 		}
 	}
-	resp, err := client.Get(urlStr)
+	return ""
+}
+
+// retrieveFolder returns the tree of the folder at urlStr. Online, the tree
+// is fetched and, if cache is set, stored in the cache; in offline mode the
+// stored tree is used.
+func retrieveFolder(urlStr string, opts FolderOptions, cache bool) (*GoogleDriveFile, error) {
+	if isOffline(opts.DownloadOptions) {
+		if !opts.Quiet {
+			fmt.Fprintln(os.Stderr, "Reading folder contents from cache")
+		}
+		return loadFolderListing(opts.DownloadOptions, urlStr)
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = "Mozilla/5.0 (compatible; gdown-go)"
+	}
+	client, err := newHTTPClient(opts.DownloadOptions)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to retrieve folder contents, status: %s", resp.Status)
+	if !opts.Quiet {
+		fmt.Fprintln(os.Stderr, "Retrieving folder contents")
 	}
-	bodyBytes, err := io.ReadAll(resp.Body)
+	parsed, err := ClassifyUrl(urlStr)
 	if err != nil {
 		return nil, err
 	}
-	bodyStr := string(bodyBytes)
-	gfile, children, err := parseGoogleDriveFile(urlStr, bodyStr)
+	if parsed == nil || parsed.ID == "" {
+		return nil, fmt.Errorf("not a Google Drive folder URL: %s", urlStr)
+	}
+	b := newBackend(client, opts.DownloadOptions)
+	if s, ok := b.(*scraperBackend); ok {
+		s.remainingOk = opts.RemainingOk
+	}
+	gfile, err := folderTree(b, parsed.ID, parsed.ResourceKey, opts)
 	if err != nil {
 		return nil, err
 	}
-	for _, child := range children {
-		if child.Type != "application/vnd.google-apps.folder" {
-			if !quiet {
-				fmt.Fprintf(os.Stderr, "Processing file %s %s\n", child.ID, child.Name)
-			}
-			childFile := &GoogleDriveFile{
-				ID:   child.ID,
-				Name: child.Name,
-				Type: child.Type,
-			}
-			gfile.Children = append(gfile.Children, childFile)
-		} else {
-			if !quiet {
-				fmt.Fprintf(os.Stderr, "Retrieving folder %s %s\n", child.ID, child.Name)
-			}
-			subUrl := "https://drive.google.com/drive/folders/" + child.ID
-			subFolder, err := downloadAndParseGoogleDriveLink(client, subUrl, quiet, remainingOk, verify)
-			if err != nil {
-				return nil, err
-			}
-			gfile.Children = append(gfile.Children, subFolder)
-		}
+	if !cache {
+		return gfile, nil
 	}
-	if len(gfile.Children) == MAX_NUMBER_FILES && !remainingOk {
-		return nil, fmt.Errorf("folder has more than %d files", MAX_NUMBER_FILES)
+	if err := saveFolderListing(opts.DownloadOptions, urlStr, gfile); err != nil && !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Couldn't cache folder contents: %v\n", err)
 	}
 	return gfile, nil
 }
 
 // FileToDownload holds information for a file (or folder) within a folder.
 type FileToDownload struct {
-	ID        string
-	Path      string // relative path within the folder
-	LocalPath string
+	ID           string
+	Path         string // relative path within the folder
+	LocalPath    string
+	ResourceKey  string
+	Kind         URLKind   // kind of Google-native items; empty for binary files
+	Format       string    // export format of Google-native items
+	Size         int64     // size of binary files; 0 when unknown
+	SizeVerified bool      // Size is reliable enough to check the download against
+	ModifiedTime time.Time // zero when unknown
+	MD5Checksum  string
+	Owner        string
 }
 
-func getDirectoryStructure(gfile *GoogleDriveFile, prevPath string) []FileToDownload {
+// DownloadURL returns the URL the file is downloaded from: the export URL
+// of Google-native items and the direct download URL of the others.
+func (f FileToDownload) DownloadURL() string {
+	if f.Format != "" {
+		if u, err := ExportURL(f.ID, f.Kind, f.Format); err == nil {
+			return withResourceKey(u, f.ResourceKey)
+		}
+	}
+	return FileDownloadURL(f.ID, f.ResourceKey)
+}
+
+// getDirectoryStructure flattens the tree of a folder. Google-native items
+// get the extension of the format they are exported in; the ones that
+// can't be exported (e.g. forms) and unresolved shortcuts are left out.
+func getDirectoryStructure(gfile *GoogleDriveFile, prevPath string, opts FolderOptions) []FileToDownload {
+	return directoryStructure(gfile, prevPath, opts, nil)
+}
+
+// directoryStructure is getDirectoryStructure, also adding the paths of the
+// items left out to skipped if it isn't nil.
+func directoryStructure(gfile *GoogleDriveFile, prevPath string, opts FolderOptions, skipped *[]string) []FileToDownload {
 	var files []FileToDownload
 	for _, child := range gfile.Children {
 		safeName := strings.ReplaceAll(child.Name, string(os.PathSeparator), "_")
@@ -699,12 +1003,46 @@ func getDirectoryStructure(gfile *GoogleDriveFile, prevPath string) []FileToDown
 			newPath := filepath.Join(prevPath, safeName)
 			// Directory entry (ID empty)
 			files = append(files, FileToDownload{ID: "", Path: newPath, LocalPath: newPath})
-			subFiles := getDirectoryStructure(child, newPath)
+			subFiles := directoryStructure(child, newPath, opts, skipped)
 			files = append(files, subFiles...)
-		} else {
-			filePath := filepath.Join(prevPath, safeName)
-			files = append(files, FileToDownload{ID: child.ID, Path: filePath})
+			continue
+		}
+		if child.IsShortcut() {
+			// Skipped while building the tree.
+			if skipped != nil {
+				*skipped = append(*skipped, filepath.Join(prevPath, safeName))
+			}
+			continue
+		}
+		f := FileToDownload{
+			ID:           child.ID,
+			ResourceKey:  child.ResourceKey,
+			Size:         child.Size,
+			SizeVerified: child.SizeVerified,
+			ModifiedTime: child.ModifiedTime,
+			MD5Checksum:  child.MD5Checksum,
+			Owner:        child.Owner,
+		}
+		if strings.HasPrefix(child.Type, "application/vnd.google-apps.") {
+			kind, ok := nativeKinds[child.Type]
+			if ok && kind != KindForm {
+				f.Kind, f.Format = kind, opts.exportFormat(kind)
+			}
+			if f.Format == "" {
+				if skipped != nil {
+					*skipped = append(*skipped, filepath.Join(prevPath, safeName))
+				}
+				if !opts.Quiet {
+					fmt.Fprintf(os.Stderr, "Skipping %s: %s items can't be downloaded\n", filepath.Join(prevPath, safeName), child.Type)
+				}
+				continue
+			}
+			if !strings.EqualFold(filepath.Ext(safeName), "."+f.Format) {
+				safeName += "." + f.Format
+			}
 		}
+		f.Path = filepath.Join(prevPath, safeName)
+		files = append(files, f)
 	}
 	return files
 }
@@ -713,10 +1051,16 @@ func getDirectoryStructure(gfile *GoogleDriveFile, prevPath string) []FileToDown
 // FileInfo holds details about a file or folder within a Google Drive folder.
 // For files, DownloadURL is provided so you can call Download individually.
 type FileInfo struct {
-	ID          string
-	Path        string // relative path within the folder
-	DownloadURL string // non-empty for files; empty for folders
-	IsFolder    bool
+	ID           string
+	Path         string // relative path within the folder
+	DownloadURL  string // non-empty for files; empty for folders
+	IsFolder     bool
+	ResourceKey  string    // resource key of link-shared items, if any
+	Size         int64     // size in bytes of binary files; 0 when unknown
+	SizeVerified bool      // Size comes from the Drive API rather than a scraped page
+	ModifiedTime time.Time // zero when unknown
+	MD5Checksum  string    // hex MD5 of binary files, if known
+	Owner        string    // email or name of the owner, if known
 }
 
 // ListFolder retrieves a folder’s structure and returns a list of FileInfo.
@@ -726,40 +1070,130 @@ func ListFolder(urlStr, id string, opts FolderOptions) ([]FileInfo, error) {
 		return nil, fmt.Errorf("either url or id must be specified")
 	}
 	if id != "" {
-		urlStr = "https://drive.google.com/drive/folders/" + id
-	}
-	if opts.UserAgent == "" {
-		opts.UserAgent = "Mozilla/5.0 (compatible; gdown-go)"
-	}
-	client, err := newHTTPClient(opts.DownloadOptions)
-	if err != nil {
-		return nil, err
+		urlStr = FolderURL(id, "")
 	}
-	if !opts.Quiet {
-		fmt.Fprintln(os.Stderr, "Retrieving folder contents")
-	}
-	gfile, err := downloadAndParseGoogleDriveLink(client, urlStr, opts.Quiet, opts.RemainingOk, opts.Verify)
+	gfile, err := retrieveFolder(urlStr, opts, true)
 	if err != nil {
 		return nil, err
 	}
-	filesToDownload := getDirectoryStructure(gfile, "")
+	filesToDownload := getDirectoryStructure(gfile, "", opts)
 	var infos []FileInfo
 	for _, f := range filesToDownload {
 		info := FileInfo{
-			ID:   f.ID,
-			Path: f.Path,
+			ID:           f.ID,
+			Path:         f.Path,
+			ResourceKey:  f.ResourceKey,
+			Size:         f.Size,
+			SizeVerified: f.SizeVerified,
+			ModifiedTime: f.ModifiedTime,
+			MD5Checksum:  f.MD5Checksum,
+			Owner:        f.Owner,
 		}
 		if f.ID == "" {
 			info.IsFolder = true
 		} else {
 			info.IsFolder = false
-			info.DownloadURL = "https://drive.google.com/uc?id=" + f.ID
+			info.DownloadURL = f.DownloadURL()
 		}
 		infos = append(infos, info)
 	}
 	return infos, nil
 }
 
+// upToDate reports whether the file at localPath matches the remote
+// metadata known for f: its size when verified, and its MD5 or the
+// modification time set by a previous download. Nothing is up to date
+// without metadata.
+func upToDate(localPath string, f FileToDownload) bool {
+	fi, err := os.Stat(localPath)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	size := f.Size
+	if !f.SizeVerified {
+		size = 0
+	}
+	if size <= 0 && f.MD5Checksum == "" && f.ModifiedTime.IsZero() {
+		return false
+	}
+	if size > 0 && fi.Size() != size {
+		return false
+	}
+	switch {
+	case f.MD5Checksum != "":
+		ok, _ := assertFileHash(localPath, "md5:"+f.MD5Checksum, true)
+		return ok
+	case !f.ModifiedTime.IsZero():
+		// Some file systems don't keep sub-second times.
+		return fi.ModTime().Truncate(time.Second).Equal(f.ModifiedTime.Truncate(time.Second))
+	}
+	return true
+}
+
+// folderRoot returns the local directory of a folder download: a directory
+// named after the folder inside output if output is empty or ends with a
+// path separator, and output itself otherwise.
+func folderRoot(output string, gfile *GoogleDriveFile) string {
+	if output == "" {
+		cwd, _ := os.Getwd()
+		output = cwd + string(os.PathSeparator)
+	}
+	if strings.HasSuffix(output, string(os.PathSeparator)) {
+		return filepath.Join(output, gfile.Name)
+	}
+	return output
+}
+
+// downloadFolderFile downloads a folder entry to localPath with
+// fetchFolderFile and records it in the cache index.
+func downloadFolderFile(f FileToDownload, localPath string, opts DownloadOptions) (string, error) {
+	downloaded, err := fetchFolderFile(f, localPath, opts)
+	if err != nil {
+		return "", err
+	}
+	recordFolderFile(opts, f, downloaded)
+	return downloaded, nil
+}
+
+// fetchFolderFile downloads a folder entry to localPath, checks it against
+// the MD5 and the verified size reported by Drive and gives it the remote
+// modification time. Sizes scraped from folder pages are only shown and
+// planned with, since the page may be stale or wrong.
+func fetchFolderFile(f FileToDownload, localPath string, opts DownloadOptions) (string, error) {
+	if f.Format != "" {
+		opts.Format = f.Format
+	}
+	if f.SizeVerified {
+		opts.ExpectedSize = f.Size
+	}
+	downloaded, err := Download(f.DownloadURL(), localPath, opts)
+	if err != nil {
+		return "", err
+	}
+	if f.MD5Checksum != "" {
+		if _, err := verifySource(downloaded, "md5:"+f.MD5Checksum); err != nil {
+			return "", err
+		}
+	}
+	if !f.ModifiedTime.IsZero() {
+		// Record the remote time so that unchanged files are skipped next time.
+		if err := os.Chtimes(downloaded, f.ModifiedTime, f.ModifiedTime); err != nil {
+			return "", err
+		}
+	}
+	return downloaded, nil
+}
+
+// recordFolderFile lets offline runs find a downloaded folder entry by URL
+// or ID.
+func recordFolderFile(opts DownloadOptions, f FileToDownload, localPath string) {
+	var hashes map[string]string
+	if f.MD5Checksum != "" {
+		hashes = map[string]string{"md5": f.MD5Checksum}
+	}
+	_ = recordFile(opts, f.DownloadURL(), localPath, hashes)
+}
+
 //
 // DownloadFolder() – downloads an entire Google Drive folder (from download_folder.py)
 //
@@ -769,19 +1203,9 @@ func DownloadFolder(urlStr, id, output string, opts FolderOptions) ([]string, er
 		return nil, fmt.Errorf("either url or id must be specified")
 	}
 	if id != "" {
-		urlStr = "https://drive.google.com/drive/folders/" + id
-	}
-	if opts.UserAgent == "" {
-		opts.UserAgent = "Mozilla/5.0 (compatible; gdown-go)"
+		urlStr = FolderURL(id, "")
 	}
-	client, err := newHTTPClient(opts.DownloadOptions)
-	if err != nil {
-		return nil, err
-	}
-	if !opts.Quiet {
-		fmt.Fprintln(os.Stderr, "Retrieving folder contents")
-	}
-	gfile, err := downloadAndParseGoogleDriveLink(client, urlStr, opts.Quiet, opts.RemainingOk, opts.Verify)
+	gfile, err := retrieveFolder(urlStr, opts, true)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to retrieve folder contents")
 		return nil, err
@@ -789,42 +1213,45 @@ func DownloadFolder(urlStr, id, output string, opts FolderOptions) ([]string, er
 	if !opts.Quiet {
 		fmt.Fprintln(os.Stderr, "Building directory structure")
 	}
-	filesToDownload := getDirectoryStructure(gfile, "")
-	if output == "" {
-		cwd, _ := os.Getwd()
-		output = cwd + string(os.PathSeparator)
-	}
-	var rootDir string
-	if strings.HasSuffix(output, string(os.PathSeparator)) {
-		rootDir = filepath.Join(output, gfile.Name)
-	} else {
-		rootDir = output
-	}
+	filesToDownload := getDirectoryStructure(gfile, "", opts)
+	rootDir := folderRoot(output, gfile)
 	if !opts.Quiet {
 		fmt.Fprintf(os.Stderr, "Creating directory %s\n", rootDir)
 	}
 	_ = os.MkdirAll(rootDir, os.ModePerm)
 	var downloadedFiles []string
+	var missing []string
 	for _, f := range filesToDownload {
 		localPath := filepath.Join(rootDir, f.Path)
-		if f.ID == "" { // folder
+		action, dopts := planEntry(f, localPath, opts.DownloadOptions)
+		switch action {
+		case "":
+			continue
+		case PlanMkdir:
 			_ = os.MkdirAll(localPath, os.ModePerm)
 			continue
-		}
-		if opts.Resume && fileExists(localPath) {
+		case PlanSkipExisting:
 			if !opts.Quiet {
 				fmt.Fprintf(os.Stderr, "Skipping already downloaded file %s\n", localPath)
 			}
 			downloadedFiles = append(downloadedFiles, localPath)
 			continue
 		}
-		fileUrl := "https://drive.google.com/uc?id=" + f.ID
-		downloaded, err := Download(fileUrl, localPath, opts.DownloadOptions)
+		downloaded, err := downloadFolderFile(f, localPath, dopts)
+		var offlineErr *OfflineError
+		if errors.As(err, &offlineErr) {
+			// Keep going so that every missing file is reported at once.
+			missing = append(missing, fmt.Sprintf("%s (%s)", f.ID, f.Path))
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
 		downloadedFiles = append(downloadedFiles, downloaded)
 	}
+	if len(missing) > 0 {
+		return downloadedFiles, &OfflineError{Missing: missing}
+	}
 	if !opts.Quiet {
 		fmt.Fprintln(os.Stderr, "Download completed")
 	}
@@ -0,0 +1,503 @@
+// Package gdowntest provides a fake Google Drive server to test code using
+// gdown without network access.
+package gdowntest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/gdown"
+)
+
+// MIME types of Drive folders and Google-native items.
+const (
+	FolderType       = "application/vnd.google-apps.folder"
+	DocumentType     = "application/vnd.google-apps.document"
+	SpreadsheetType  = "application/vnd.google-apps.spreadsheet"
+	PresentationType = "application/vnd.google-apps.presentation"
+	DrawingType      = "application/vnd.google-apps.drawing"
+	ShortcutType     = "application/vnd.google-apps.shortcut"
+)
+
+// Item is a file or folder served by a Server.
+type Item struct {
+	ID            string
+	Name          string
+	MimeType      string // FolderType for folders; empty means application/octet-stream
+	Parent        string // ID of the containing folder; empty for top-level items
+	ResourceKey   string // when set, requests must carry it like link-shared items
+	DriveID       string // shared drive holding the item; the drive's root folder has the drive's ID
+	Target        string // ID of the item a shortcut points to
+	Owner         string // email address of the owner, reported by the API
+	Content       []byte
+	ModTime       time.Time
+	ListedSize    int64             // size shown on folder pages, e.g. a stale one; 0 means the size of Content
+	Exports       map[string][]byte // content of Google-native items by export format (e.g. docx)
+	Confirm       bool              // downloads go through a virus scan warning page
+	QuotaExceeded bool              // downloads fail with the "too many users" page
+}
+
+// IsFolder reports whether the item is a folder.
+func (i *Item) IsFolder() bool {
+	return i.MimeType == FolderType
+}
+
+// Server is a fake Google Drive serving an in-memory tree of items through
+// the web pages and download links scraped by gdown, and through the Drive
+// v3 API under /drive/v3. Items may be added or changed between requests.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	items    map[string]*Item
+	requests []string
+}
+
+// NewServer starts a server with no items. Call Close when done.
+func NewServer() *Server {
+	s := &Server{items: map[string]*Item{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /drive/folders/{id}", s.folderPage)
+	mux.HandleFunc("GET /file/d/{id}/view", s.viewPage)
+	mux.HandleFunc("GET /uc", s.download)
+	mux.HandleFunc("GET /{segment}/d/{id}/edit", s.viewPage)
+	mux.HandleFunc("GET /{segment}/d/{id}/export", s.export)
+	mux.HandleFunc("GET /drawings/d/{id}/export/{format}", s.export)
+	mux.HandleFunc("GET /drive/v3/files", s.apiList)
+	mux.HandleFunc("GET /drive/v3/files/{id}", s.apiGet)
+	mux.HandleFunc("GET /drive/v3/files/{id}/export", s.apiExport)
+	mux.HandleFunc("GET /drive/v3/drives/{id}", s.apiDrive)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+		s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// Install points gdown's base URLs at the server and returns a function
+// restoring them.
+func (s *Server) Install() (restore func()) {
+	drive, docs, content, api := gdown.DriveBaseURL, gdown.DocsBaseURL, gdown.UserContentBaseURL, gdown.DriveAPIBaseURL
+	gdown.DriveBaseURL = s.URL
+	gdown.DocsBaseURL = s.URL
+	gdown.UserContentBaseURL = s.URL
+	gdown.DriveAPIBaseURL = s.URL + "/drive/v3"
+	return func() {
+		gdown.DriveBaseURL, gdown.DocsBaseURL, gdown.UserContentBaseURL, gdown.DriveAPIBaseURL = drive, docs, content, api
+	}
+}
+
+// Add adds or replaces an item and returns it.
+func (s *Server) Add(item *Item) *Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[item.ID] = item
+	return item
+}
+
+// AddFolder adds a folder inside parent (empty for a top-level folder).
+func (s *Server) AddFolder(parent, id, name string) *Item {
+	return s.Add(&Item{ID: id, Name: name, MimeType: FolderType, Parent: parent})
+}
+
+// AddFile adds a binary file inside parent.
+func (s *Server) AddFile(parent, id, name string, content []byte) *Item {
+	return s.Add(&Item{ID: id, Name: name, Parent: parent, Content: content})
+}
+
+// AddShortcut adds a shortcut to target inside parent.
+func (s *Server) AddShortcut(parent, id, name, target string) *Item {
+	return s.Add(&Item{ID: id, Name: name, MimeType: ShortcutType, Parent: parent, Target: target})
+}
+
+// Remove removes an item; its children become unreachable.
+func (s *Server) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, id)
+}
+
+// Requests returns the method and URI of every request received so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// item returns the item id if the request is allowed to access it.
+func (s *Server) item(r *http.Request, id string) (*Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[id]
+	if !ok {
+		return nil, false
+	}
+	if item.ResourceKey != "" && r.URL.Query().Get("resourcekey") != item.ResourceKey &&
+		!strings.Contains(r.Header.Get("X-Goog-Drive-Resource-Keys"), id+"/"+item.ResourceKey) {
+		return nil, false
+	}
+	return item, true
+}
+
+// children returns the items of a folder, folders first and by name.
+func (s *Server) children(id string) []*Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items []*Item
+	for _, item := range s.items {
+		if item.Parent == id {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].IsFolder() != items[j].IsFolder() {
+			return items[i].IsFolder()
+		}
+		return items[i].Name < items[j].Name
+	})
+	return items
+}
+
+//
+// Web pages and download links
+//
+
+// titleSuffixes are the page title suffixes of each item type.
+var titleSuffixes = map[string]string{
+	DocumentType:     " - Google Docs",
+	SpreadsheetType:  " - Google Sheets",
+	PresentationType: " - Google Slides",
+	DrawingType:      " - Google Drawings",
+}
+
+// jsEscaper escapes JSON for a single-quoted JavaScript string with \xHH
+// sequences, like Drive does.
+var jsEscaper = strings.NewReplacer(`\`, `\x5c`, `"`, `\x22`, `'`, `\x27`, `<`, `\x3c`, `>`, `\x3e`, `&`, `\x26`)
+
+// redirectShortcut redirects requests for a shortcut to the page of its
+// target, as Drive does, and reports whether it did.
+func (s *Server) redirectShortcut(w http.ResponseWriter, r *http.Request, item *Item) bool {
+	if item.MimeType != ShortcutType {
+		return false
+	}
+	s.mu.Lock()
+	target, ok := s.items[item.Target]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return true
+	}
+	link := "/file/d/" + url.PathEscape(target.ID) + "/view"
+	if target.IsFolder() {
+		link = "/drive/folders/" + url.PathEscape(target.ID)
+	}
+	if target.ResourceKey != "" {
+		link += "?resourcekey=" + url.QueryEscape(target.ResourceKey)
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+	return true
+}
+
+// folderPage serves the folder view with its items in a _DRIVE_ivd blob.
+func (s *Server) folderPage(w http.ResponseWriter, r *http.Request) {
+	folder, ok := s.item(r, r.PathValue("id"))
+	if ok && s.redirectShortcut(w, r, folder) {
+		return
+	}
+	if !ok || !folder.IsFolder() {
+		http.NotFound(w, r)
+		return
+	}
+	var entries [][]interface{}
+	for _, child := range s.children(folder.ID) {
+		var rk interface{}
+		if child.ResourceKey != "" {
+			rk = child.ResourceKey
+		}
+		// The modification time (ms) and size are at positions 9 and 13.
+		entry := []interface{}{child.ID, []string{folder.ID}, child.Name, child.mimeType(), rk, nil, nil, nil, nil, nil, nil, nil, nil, nil}
+		if !child.ModTime.IsZero() {
+			entry[9] = child.ModTime.UnixMilli()
+		}
+		if child.binary() {
+			entry[13] = int64(len(child.Content))
+			if child.ListedSize > 0 {
+				entry[13] = child.ListedSize
+			}
+		}
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal([]interface{}{entries})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html><html><head><title>%s - Google Drive</title></head><body>"+
+		"<script>window['_DRIVE_ivd'] = '%s';</script></body></html>", html.EscapeString(folder.Name), jsEscaper.Replace(string(data)))
+}
+
+// viewPage serves the view page of a file or the editor of a native item.
+func (s *Server) viewPage(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.item(r, r.PathValue("id"))
+	if ok && s.redirectShortcut(w, r, item) {
+		return
+	}
+	if !ok || item.IsFolder() {
+		http.NotFound(w, r)
+		return
+	}
+	suffix, ok := titleSuffixes[item.MimeType]
+	if !ok {
+		suffix = " - Google Drive"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html><html><head><title>%s</title></head><body></body></html>", html.EscapeString(item.Name+suffix))
+}
+
+// download serves uc?id= links, with the warning and quota pages of items
+// that have them.
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	item, ok := s.item(r, q.Get("id"))
+	if !ok || item.IsFolder() || item.MimeType == ShortcutType || titleSuffixes[item.MimeType] != "" {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case item.QuotaExceeded:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<!DOCTYPE html><html><head><title>Google Drive - Quota exceeded</title></head><body>"+
+			"<p>Too many users have viewed or downloaded this file recently. Please try accessing the file again later.</p></body></html>")
+	case item.Confirm && q.Get("confirm") == "":
+		link := "/uc?export=download&confirm=t&id=" + url.QueryEscape(item.ID)
+		if item.ResourceKey != "" {
+			link += "&resourcekey=" + url.QueryEscape(item.ResourceKey)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<!DOCTYPE html><html><head><title>Google Drive - Virus scan warning</title></head><body>"+
+			"<p>%s is too large for Google to scan for viruses.</p><a id=\"uc-download-link\" href=\"%s\">Download anyway</a></body></html>",
+			html.EscapeString(item.Name), html.EscapeString(link))
+	default:
+		serveContent(w, r, item.Name, item.ModTime, item.Content)
+	}
+}
+
+// export serves the export links of Google-native items.
+func (s *Server) export(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.item(r, r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	format := r.PathValue("format")
+	if format == "" {
+		format = r.URL.Query().Get("format")
+	}
+	data, ok := item.Exports[format]
+	if !ok {
+		http.Error(w, "unsupported export format", http.StatusBadRequest)
+		return
+	}
+	serveContent(w, r, item.Name+"."+format, item.ModTime, data)
+}
+
+// serveContent sends a file download, honoring Range and conditional
+// requests. The ETag is the MD5 of the content.
+func serveContent(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, data []byte) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s", name, url.PathEscape(name)))
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+}
+
+//
+// Drive v3 API
+//
+
+type apiFile struct {
+	ID              string           `json:"id"`
+	Name            string           `json:"name"`
+	MimeType        string           `json:"mimeType"`
+	ResourceKey     string           `json:"resourceKey,omitempty"`
+	DriveID         string           `json:"driveId,omitempty"`
+	Size            string           `json:"size,omitempty"`
+	ModifiedTime    string           `json:"modifiedTime,omitempty"`
+	MD5Checksum     string           `json:"md5Checksum,omitempty"`
+	Owners          []apiUser        `json:"owners,omitempty"`
+	ShortcutDetails *shortcutDetails `json:"shortcutDetails,omitempty"`
+}
+
+type apiUser struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+type shortcutDetails struct {
+	TargetID          string `json:"targetId"`
+	TargetMimeType    string `json:"targetMimeType,omitempty"`
+	TargetResourceKey string `json:"targetResourceKey,omitempty"`
+}
+
+// binary reports whether the item is a file with content of its own.
+func (i *Item) binary() bool {
+	return !i.IsFolder() && !strings.HasPrefix(i.MimeType, "application/vnd.google-apps.")
+}
+
+func (i *Item) mimeType() string {
+	if i.MimeType == "" {
+		return "application/octet-stream"
+	}
+	return i.MimeType
+}
+
+func (s *Server) apiFile(i *Item) apiFile {
+	f := apiFile{ID: i.ID, Name: i.Name, MimeType: i.mimeType(), ResourceKey: i.ResourceKey, DriveID: i.DriveID}
+	if i.binary() {
+		f.Size = strconv.Itoa(len(i.Content))
+		f.MD5Checksum = fmt.Sprintf("%x", md5.Sum(i.Content))
+	}
+	if !i.ModTime.IsZero() {
+		f.ModifiedTime = i.ModTime.UTC().Format(time.RFC3339Nano)
+	}
+	if i.Owner != "" && i.DriveID == "" {
+		f.Owners = []apiUser{{EmailAddress: i.Owner}}
+	}
+	if i.MimeType == ShortcutType {
+		f.ShortcutDetails = &shortcutDetails{TargetID: i.Target}
+		s.mu.Lock()
+		if target, ok := s.items[i.Target]; ok {
+			f.ShortcutDetails.TargetMimeType = target.mimeType()
+			f.ShortcutDetails.TargetResourceKey = target.ResourceKey
+		}
+		s.mu.Unlock()
+	}
+	return f
+}
+
+// exportFormats maps the MIME types requested from files.export to formats.
+var exportFormats = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   "docx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         "xlsx",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "pptx",
+	"application/vnd.oasis.opendocument.text":                                   "odt",
+	"application/vnd.oasis.opendocument.spreadsheet":                            "ods",
+	"application/vnd.oasis.opendocument.presentation":                           "odp",
+	"application/pdf":           "pdf",
+	"application/rtf":           "rtf",
+	"text/plain":                "txt",
+	"text/markdown":             "md",
+	"text/html":                 "html",
+	"application/epub+zip":      "epub",
+	"text/csv":                  "csv",
+	"text/tab-separated-values": "tsv",
+	"image/png":                 "png",
+	"image/jpeg":                "jpeg",
+	"image/svg+xml":             "svg",
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": status, "message": msg}})
+}
+
+// apiGet serves files.get, with alt=media for the content.
+func (s *Server) apiGet(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.item(r, r.PathValue("id"))
+	if !ok {
+		apiError(w, http.StatusNotFound, "File not found: "+r.PathValue("id")+".")
+		return
+	}
+	if r.URL.Query().Get("alt") != "media" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.apiFile(item))
+		return
+	}
+	if item.IsFolder() || item.MimeType == ShortcutType || titleSuffixes[item.MimeType] != "" {
+		apiError(w, http.StatusForbidden, "Only files with binary content can be downloaded. Use Export with Docs Editors files.")
+		return
+	}
+	if item.QuotaExceeded {
+		apiError(w, http.StatusForbidden, "The download quota for this file has been exceeded.")
+		return
+	}
+	serveContent(w, r, item.Name, item.ModTime, item.Content)
+}
+
+// apiExport serves files.export.
+func (s *Server) apiExport(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.item(r, r.PathValue("id"))
+	if !ok {
+		apiError(w, http.StatusNotFound, "File not found: "+r.PathValue("id")+".")
+		return
+	}
+	data, ok := item.Exports[exportFormats[r.URL.Query().Get("mimeType")]]
+	if !ok {
+		apiError(w, http.StatusBadRequest, "The requested conversion is not supported.")
+		return
+	}
+	w.Header().Set("Content-Type", r.URL.Query().Get("mimeType"))
+	_, _ = w.Write(data)
+}
+
+// parentQueryRe matches the "'<id>' in parents" clause of files.list queries.
+var parentQueryRe = regexp.MustCompile(`'((?:[^'\\]|\\.)*)' in parents`)
+
+// apiList serves files.list for "'<id>' in parents" queries, paging with
+// pageSize and pageToken.
+func (s *Server) apiList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	m := parentQueryRe.FindStringSubmatch(q.Get("q"))
+	if m == nil {
+		apiError(w, http.StatusBadRequest, "Only parent queries are supported.")
+		return
+	}
+	id := strings.ReplaceAll(m[1], `\'`, `'`)
+	if _, ok := s.item(r, id); !ok {
+		apiError(w, http.StatusNotFound, "File not found: "+id+".")
+		return
+	}
+	children := s.children(id)
+	start, _ := strconv.Atoi(q.Get("pageToken"))
+	size, err := strconv.Atoi(q.Get("pageSize"))
+	if err != nil || size <= 0 {
+		size = 100
+	}
+	page := struct {
+		NextPageToken string    `json:"nextPageToken,omitempty"`
+		Files         []apiFile `json:"files"`
+	}{Files: []apiFile{}}
+	for i := start; i < len(children) && i < start+size; i++ {
+		page.Files = append(page.Files, s.apiFile(children[i]))
+	}
+	if start+size < len(children) {
+		page.NextPageToken = strconv.Itoa(start + size)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+// apiDrive serves drives.get for the root folders of shared drives.
+func (s *Server) apiDrive(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.item(r, r.PathValue("id"))
+	if !ok || item.DriveID != item.ID {
+		apiError(w, http.StatusNotFound, "Shared drive not found: "+r.PathValue("id"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"id": item.ID, "name": item.Name})
+}
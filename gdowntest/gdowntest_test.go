@@ -0,0 +1,186 @@
+package gdowntest_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/igolaizola/gdown"
+	"github.com/igolaizola/gdown/gdowntest"
+)
+
+// get requests path from the server and returns the response with its body.
+func get(t *testing.T, srv *gdowntest.Server, path string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest("GET", srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestServerDownloadLinks(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	srv.AddFile("", "a", "a.txt", []byte("alpha"))
+	srv.Add(&gdowntest.Item{ID: "big", Name: "big.bin", Content: []byte("large"), Confirm: true})
+	srv.Add(&gdowntest.Item{ID: "popular", Name: "popular.bin", Content: []byte("data"), QuotaExceeded: true})
+	srv.Add(&gdowntest.Item{ID: "private", Name: "p.txt", Content: []byte("secret"), ResourceKey: "KEY"})
+
+	resp, body := get(t, srv, "/uc?id=a", nil)
+	if resp.StatusCode != http.StatusOK || body != "alpha" {
+		t.Errorf("uc?id=a: %s %q", resp.Status, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, `filename="a.txt"`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	resp, body = get(t, srv, "/uc?id=a", http.Header{"Range": {"bytes=2-"}})
+	if resp.StatusCode != http.StatusPartialContent || body != "pha" {
+		t.Errorf("range request: %s %q", resp.Status, body)
+	}
+	resp, _ = get(t, srv, "/uc?id=a", http.Header{"If-None-Match": {resp.Header.Get("ETag")}})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional request: %s", resp.Status)
+	}
+
+	if _, body = get(t, srv, "/uc?id=big", nil); !strings.Contains(body, "Virus scan warning") || !strings.Contains(body, "confirm=t") {
+		t.Errorf("no warning page for big.bin: %q", body)
+	}
+	if _, body = get(t, srv, "/uc?id=big&confirm=t", nil); body != "large" {
+		t.Errorf("confirmed download holds %q", body)
+	}
+	if _, body = get(t, srv, "/uc?id=popular", nil); !strings.Contains(body, "Too many users") {
+		t.Errorf("no quota page for popular.bin: %q", body)
+	}
+
+	if resp, _ = get(t, srv, "/uc?id=private", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("item without its resource key: %s", resp.Status)
+	}
+	if _, body = get(t, srv, "/uc?id=private", http.Header{"X-Goog-Drive-Resource-Keys": {"private/KEY"}}); body != "secret" {
+		t.Errorf("item with its resource key holds %q", body)
+	}
+	if _, body = get(t, srv, "/uc?id=private&resourcekey=KEY", nil); body != "secret" {
+		t.Errorf("item with a resourcekey parameter holds %q", body)
+	}
+
+	if n := len(srv.Requests()); n != 9 {
+		t.Errorf("%d requests recorded, want 9", n)
+	}
+}
+
+func TestServerPages(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	srv.AddFolder("", "root", "root")
+	srv.AddFile("root", "a", "a.txt", []byte("alpha"))
+	srv.Add(&gdowntest.Item{ID: "doc", Name: "notes", MimeType: gdowntest.DocumentType, Parent: "root",
+		Exports: map[string][]byte{"pdf": []byte("pdf data")}})
+	srv.AddShortcut("root", "link", "link.txt", "a")
+
+	if _, body := get(t, srv, "/drive/folders/root", nil); !strings.Contains(body, "_DRIVE_ivd") || !strings.Contains(body, "notes") {
+		t.Errorf("folder page: %q", body)
+	}
+	if _, body := get(t, srv, "/document/d/doc/edit", nil); !strings.Contains(body, "<title>notes - Google Docs</title>") {
+		t.Errorf("editor page: %q", body)
+	}
+	if resp, body := get(t, srv, "/document/d/doc/export?format=pdf", nil); resp.StatusCode != http.StatusOK || body != "pdf data" {
+		t.Errorf("export: %s %q", resp.Status, body)
+	}
+	if resp, _ := get(t, srv, "/document/d/doc/export?format=xlsx", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unsupported export: %s", resp.Status)
+	}
+	if resp, _ := get(t, srv, "/file/d/link/view", nil); resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/file/d/a/view" {
+		t.Errorf("shortcut: %s to %q", resp.Status, resp.Header.Get("Location"))
+	}
+}
+
+func TestServerAPI(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	srv.AddFolder("", "root", "root")
+	srv.AddFolder("root", "sub", "sub")
+	srv.AddFile("root", "b", "b.txt", []byte("bravo"))
+	srv.AddFile("root", "a", "a.txt", []byte("alpha"))
+	srv.Add(&gdowntest.Item{ID: "doc", Name: "notes", MimeType: gdowntest.DocumentType, Parent: "root",
+		Exports: map[string][]byte{"pdf": []byte("pdf data")}})
+
+	// Folders come first, then files by name, one page at a time.
+	var names []string
+	token := ""
+	for {
+		q := url.Values{"q": {"'root' in parents"}, "pageSize": {"2"}, "pageToken": {token}}
+		_, body := get(t, srv, "/drive/v3/files?"+q.Encode(), nil)
+		var page struct {
+			NextPageToken string `json:"nextPageToken"`
+			Files         []struct {
+				Name string `json:"name"`
+			} `json:"files"`
+		}
+		if err := json.Unmarshal([]byte(body), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range page.Files {
+			names = append(names, f.Name)
+		}
+		if token = page.NextPageToken; token == "" {
+			break
+		}
+	}
+	if got := strings.Join(names, ","); got != "sub,a.txt,b.txt,notes" {
+		t.Errorf("listing = %s", got)
+	}
+
+	_, body := get(t, srv, "/drive/v3/files/a", nil)
+	var file struct {
+		Size        string `json:"size"`
+		MD5Checksum string `json:"md5Checksum"`
+	}
+	if err := json.Unmarshal([]byte(body), &file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Size != "5" || file.MD5Checksum == "" {
+		t.Errorf("metadata of a.txt: %s", body)
+	}
+	if _, body := get(t, srv, "/drive/v3/files/a?alt=media", nil); body != "alpha" {
+		t.Errorf("media of a.txt: %q", body)
+	}
+	if resp, _ := get(t, srv, "/drive/v3/files/doc?alt=media", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("media of a document: %s", resp.Status)
+	}
+	if _, body := get(t, srv, "/drive/v3/files/doc/export?mimeType=application%2Fpdf", nil); body != "pdf data" {
+		t.Errorf("export of notes: %q", body)
+	}
+	if resp, _ := get(t, srv, "/drive/v3/files/missing", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing file: %s", resp.Status)
+	}
+}
+
+func TestServerInstall(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	drive, api := gdown.DriveBaseURL, gdown.DriveAPIBaseURL
+	restore := srv.Install()
+	if gdown.DriveBaseURL != srv.URL || gdown.DocsBaseURL != srv.URL || gdown.DriveAPIBaseURL != srv.URL+"/drive/v3" {
+		t.Errorf("base URLs not pointed at the server: %s, %s", gdown.DriveBaseURL, gdown.DriveAPIBaseURL)
+	}
+	restore()
+	if gdown.DriveBaseURL != drive || gdown.DriveAPIBaseURL != api {
+		t.Errorf("base URLs not restored: %s, %s", gdown.DriveBaseURL, gdown.DriveAPIBaseURL)
+	}
+}
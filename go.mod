@@ -5,10 +5,10 @@ go 1.23
 require (
 	github.com/PuerkitoBio/goquery v1.10.1
 	github.com/peterbourgon/ff/v3 v3.4.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
 	github.com/andybalholm/cascadia v1.3.3 // indirect
 	golang.org/x/net v0.33.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
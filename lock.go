@@ -0,0 +1,94 @@
+package gdown
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//
+// Cross-process advisory locks for cache entries
+//
+
+const (
+	// DefaultLockTimeout is how long CachedDownload waits for another
+	// process to finish downloading the same entry.
+	DefaultLockTimeout = 30 * time.Minute
+
+	// lockPollInterval is how often a waiting process retries the lock.
+	lockPollInterval = 200 * time.Millisecond
+)
+
+// ErrLockTimeout is returned when a cache entry lock can't be acquired in time.
+var ErrLockTimeout = errors.New("timed out waiting for cache lock")
+
+// fileLock is an advisory lock held on an open lock file through the
+// operating system (flock on Unix, LockFileEx on Windows). The lock goes
+// away with the process, so a crashed holder never leaves a stale lock
+// behind that others would have to break.
+//
+// Lock files are never removed: a waiter may already have the file open,
+// and removing it would let a third process lock a new file under the same
+// name while the waiter locks the old one.
+type fileLock struct {
+	f *os.File
+}
+
+// cacheLockPath returns the lock file guarding a cached file.
+func cacheLockPath(cacheRoot, outputPath string) string {
+	if abs, err := filepath.Abs(outputPath); err == nil {
+		outputPath = abs
+	}
+	sum := sha256.Sum256([]byte(outputPath))
+	return filepath.Join(cacheRoot, "locks", hex.EncodeToString(sum[:])+".lock")
+}
+
+// acquireLock locks the lock file at path, waiting up to timeout for
+// another holder to release it.
+func acquireLock(path string, timeout time.Duration, quiet bool) (*fileLock, error) {
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			// Record the holder to help debugging a lock that is held too long.
+			host, _ := os.Hostname()
+			_ = f.Truncate(0)
+			_, _ = f.WriteAt([]byte(fmt.Sprintf("%d %s %s\n", os.Getpid(), host, time.Now().UTC().Format(time.RFC3339))), 0)
+			return &fileLock{f: f}, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w: %s", ErrLockTimeout, path)
+		}
+		if !waiting && !quiet {
+			fmt.Fprintln(os.Stderr, "Waiting for another process to finish downloading")
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// release unlocks and closes the lock file.
+func (l *fileLock) release() {
+	_ = unlockFile(l.f)
+	_ = l.f.Close()
+}
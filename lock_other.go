@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || windows)
+
+package gdown
+
+import "os"
+
+// tryLockFile always succeeds: there is no cross-process file locking on
+// this platform, so concurrent processes may download the same entry.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlockFile is a no-op on this platform.
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package gdown
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireLockExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locks", "entry.lock")
+	var holders, maxHolders, acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				l, err := acquireLock(path, 10*time.Second, true)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n := holders.Add(1)
+				for {
+					m := maxHolders.Load()
+					if n <= m || maxHolders.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				acquired.Add(1)
+				time.Sleep(5 * time.Millisecond)
+				holders.Add(-1)
+				l.release()
+			}
+		}()
+	}
+	wg.Wait()
+	if got := maxHolders.Load(); got != 1 {
+		t.Errorf("lock held by %d acquirers at once", got)
+	}
+	if got := acquired.Load(); got != 20 {
+		t.Errorf("lock acquired %d times, want 20", got)
+	}
+}
+
+func TestAcquireLockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "entry.lock")
+	l, err := acquireLock(path, time.Second, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.release()
+	if _, err := acquireLock(path, 300*time.Millisecond, true); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("got %v, want ErrLockTimeout", err)
+	}
+}
@@ -0,0 +1,349 @@
+package gdown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//
+// Declarative dataset manifests and lockfiles
+//
+
+// Manifest declares a set of named artifacts to fetch.
+type Manifest struct {
+	Artifacts []Artifact `yaml:"artifacts" json:"artifacts"`
+}
+
+// Artifact is a file or folder declared in a manifest. Relative paths are
+// resolved against the manifest's directory.
+type Artifact struct {
+	Name          string   `yaml:"name" json:"name"`
+	URL           string   `yaml:"url" json:"url"`
+	Folder        bool     `yaml:"folder,omitempty" json:"folder,omitempty"`   // detected from the URL when not set
+	Dest          string   `yaml:"dest,omitempty" json:"dest,omitempty"`       // file (or folder) destination; files default to the cache
+	Hash          string   `yaml:"hash,omitempty" json:"hash,omitempty"`       // expected hash in the format <algo>:<hash_value>
+	Mirrors       []string `yaml:"mirrors,omitempty" json:"mirrors,omitempty"` // fallback URLs of a file artifact
+	Extract       bool     `yaml:"extract,omitempty" json:"extract,omitempty"`
+	ExtractTo     string   `yaml:"extract_to,omitempty" json:"extract_to,omitempty"` // defaults to the file's directory
+	DeleteArchive bool     `yaml:"delete_archive,omitempty" json:"delete_archive,omitempty"`
+}
+
+// isFolder reports whether the artifact is a Google Drive folder.
+func (a *Artifact) isFolder() bool {
+	if a.Folder {
+		return true
+	}
+	p, err := ClassifyUrl(a.URL)
+	return err == nil && p != nil && p.Kind == KindFolder
+}
+
+// LoadManifest reads a manifest file. Files ending in .json are parsed as
+// JSON and anything else as YAML.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &m)
+	} else {
+		err = yaml.UnmarshalStrict(data, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse manifest %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i, a := range m.Artifacts {
+		switch {
+		case a.Name == "":
+			return nil, fmt.Errorf("manifest %s: artifact %d has no name", path, i+1)
+		case a.URL == "":
+			return nil, fmt.Errorf("manifest %s: artifact %s has no url", path, a.Name)
+		case seen[a.Name]:
+			return nil, fmt.Errorf("manifest %s: duplicate artifact %s", path, a.Name)
+		case a.isFolder() && (a.Extract || a.Hash != "" || len(a.Mirrors) > 0):
+			return nil, fmt.Errorf("manifest %s: artifact %s is a folder and can't have hash, mirrors or extract options", path, a.Name)
+		}
+		seen[a.Name] = true
+	}
+	return &m, nil
+}
+
+// Lockfile records the resolved files of every artifact of a manifest.
+type Lockfile struct {
+	Artifacts []LockedArtifact `json:"artifacts"`
+}
+
+// LockedArtifact is the resolved state of an artifact.
+type LockedArtifact struct {
+	Name  string       `json:"name"`
+	URL   string       `json:"url"`
+	Files []LockedFile `json:"files"`
+}
+
+// LockedFile is a file of a locked artifact. Path is relative to the
+// manifest's directory, or to the cache directory (as "$CACHE/<name>") for
+// files kept in the cache, so that lockfiles don't depend on the machine.
+type LockedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	MD5    string `json:"md5"`
+	SHA256 string `json:"sha256"`
+}
+
+// LockfilePath returns the lockfile of a manifest: the manifest path with
+// its extension replaced by ".lock".
+func LockfilePath(manifestPath string) string {
+	return strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath)) + ".lock"
+}
+
+// LoadLockfile reads a lockfile. A missing lockfile returns an empty one.
+func LoadLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Lockfile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lock Lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("couldn't parse lockfile %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// WriteLockfile writes lock to path, with artifacts sorted by name so that
+// the file diffs cleanly.
+func WriteLockfile(path string, lock *Lockfile) error {
+	sort.Slice(lock.Artifacts, func(i, j int) bool { return lock.Artifacts[i].Name < lock.Artifacts[j].Name })
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// artifact returns the locked state of the named artifact, or nil.
+func (l *Lockfile) artifact(name string) *LockedArtifact {
+	for i := range l.Artifacts {
+		if l.Artifacts[i].Name == name {
+			return &l.Artifacts[i]
+		}
+	}
+	return nil
+}
+
+// FetchOptions holds settings for FetchManifest.
+type FetchOptions struct {
+	CacheOptions
+	Dir    string // base directory for relative paths, usually the manifest's directory
+	Update bool   // re-resolve artifacts instead of verifying them against the lockfile
+	Frozen bool   // fail on artifacts missing from the lockfile instead of adding them
+}
+
+// FetchResult is the outcome of fetching one artifact.
+type FetchResult struct {
+	Artifact Artifact
+	Locked   LockedArtifact
+	Status   CacheStatus // cache status of file artifacts
+	Err      error
+}
+
+// FetchManifest materializes every artifact of m. Files are fetched with
+// CachedDownloadWithOptions and folders with DownloadFolder. Artifacts
+// present in lock are verified against their recorded hashes unless
+// opts.Update is set. It returns the result of each artifact and the
+// updated lockfile, which keeps the previous state of failed artifacts.
+func FetchManifest(m *Manifest, lock *Lockfile, opts FetchOptions) ([]FetchResult, *Lockfile) {
+	if lock == nil {
+		lock = &Lockfile{}
+	}
+	if opts.Dir == "" {
+		opts.Dir = "."
+	}
+	var results []FetchResult
+	newLock := &Lockfile{}
+	for _, a := range m.Artifacts {
+		var locked *LockedArtifact
+		if l := lock.artifact(a.Name); l != nil && l.URL == a.URL && !opts.Update {
+			locked = l
+		}
+		res := FetchResult{Artifact: a}
+		if locked == nil && opts.Frozen {
+			res.Err = fmt.Errorf("artifact %s is not in the lockfile", a.Name)
+		} else if a.isFolder() {
+			res.Locked, res.Err = fetchFolderArtifact(a, locked, opts)
+		} else {
+			res.Locked, res.Status, res.Err = fetchFileArtifact(a, locked, opts)
+		}
+		if res.Err != nil {
+			if l := lock.artifact(a.Name); l != nil {
+				newLock.Artifacts = append(newLock.Artifacts, *l)
+			}
+		} else {
+			newLock.Artifacts = append(newLock.Artifacts, res.Locked)
+		}
+		results = append(results, res)
+	}
+	return results, newLock
+}
+
+// resolvePath joins relative paths to dir.
+func resolvePath(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// lockedCachePrefix starts the locked paths of files kept in the cache.
+const lockedCachePrefix = "$CACHE/"
+
+// lockedPath returns how p is recorded in a lockfile: relative to the
+// cache root for cached files and relative to dir otherwise. p is only
+// kept as is if it can't be made relative (e.g. on another Windows drive).
+func lockedPath(dir, cacheRoot, p string) string {
+	absP, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	if absRoot, err := filepath.Abs(cacheRoot); err == nil && isWithin(absRoot, absP) {
+		if rel, err := filepath.Rel(absRoot, absP); err == nil {
+			return lockedCachePrefix + filepath.ToSlash(rel)
+		}
+	}
+	if absDir, err := filepath.Abs(dir); err == nil {
+		if rel, err := filepath.Rel(absDir, absP); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(p)
+}
+
+// resolveLockedPath returns the local path of a path recorded by lockedPath.
+func resolveLockedPath(dir, cacheRoot, p string) string {
+	if rest, ok := strings.CutPrefix(p, lockedCachePrefix); ok {
+		return filepath.Join(cacheRoot, filepath.FromSlash(rest))
+	}
+	return resolvePath(dir, filepath.FromSlash(p))
+}
+
+// fetchFileArtifact fetches a file artifact through the cache.
+func fetchFileArtifact(a Artifact, locked *LockedArtifact, opts FetchOptions) (LockedArtifact, CacheStatus, error) {
+	out := LockedArtifact{Name: a.Name, URL: a.URL}
+	hash := a.Hash
+	if hash == "" && locked != nil && len(locked.Files) == 1 {
+		hash = "sha256:" + locked.Files[0].SHA256
+	}
+	dest := resolvePath(opts.Dir, a.Dest)
+	if dest != "" {
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return out, "", err
+		}
+	}
+	var post *Postprocessor
+	if a.Extract {
+		to := resolvePath(opts.Dir, a.ExtractTo)
+		if a.DeleteArchive {
+			post = ExtractAndDeletePostprocessor(to)
+		} else {
+			post = ExtractPostprocessor(to)
+		}
+	}
+	copts := opts.CacheOptions
+	copts.Mirrors = a.Mirrors
+	res, err := CachedDownloadWithOptions(a.URL, dest, hash, post, copts)
+	if err != nil {
+		if hash != a.Hash {
+			return out, "", fmt.Errorf("artifact %s (locked to %s): %w", a.Name, hash, err)
+		}
+		return out, "", fmt.Errorf("artifact %s: %w", a.Name, err)
+	}
+	f := LockedFile{Path: lockedPath(opts.Dir, getCacheRoot(opts.CacheDir), res.Path)}
+	if res.Entry != nil && res.Entry.Hashes["sha256"] != "" {
+		f.Size, f.MD5, f.SHA256 = res.Entry.Size, res.Entry.Hashes["md5"], res.Entry.Hashes["sha256"]
+	} else {
+		hashes, size, err := fileHashes(res.Path)
+		if err != nil {
+			return out, "", fmt.Errorf("artifact %s: %w", a.Name, err)
+		}
+		f.Size, f.MD5, f.SHA256 = size, hashes["md5"], hashes["sha256"]
+	}
+	if locked != nil && len(locked.Files) == 1 && locked.Files[0].SHA256 != f.SHA256 {
+		return out, "", fmt.Errorf("artifact %s: %s doesn't match the lockfile", a.Name, f.Path)
+	}
+	out.Files = []LockedFile{f}
+	return out, res.Status, nil
+}
+
+// fetchFolderArtifact downloads a folder artifact. With a locked state,
+// local files that don't match it are downloaded again and the result must
+// match the lockfile exactly.
+func fetchFolderArtifact(a Artifact, locked *LockedArtifact, opts FetchOptions) (LockedArtifact, error) {
+	out := LockedArtifact{Name: a.Name, URL: a.URL}
+	dest := resolvePath(opts.Dir, a.Dest)
+	if dest == "" {
+		dest = opts.Dir + string(os.PathSeparator)
+	}
+	expected := map[string]LockedFile{}
+	if locked != nil {
+		for _, f := range locked.Files {
+			expected[f.Path] = f
+			p := resolveLockedPath(opts.Dir, getCacheRoot(opts.CacheDir), f.Path)
+			if !fileExists(p) {
+				continue
+			}
+			if ok, _ := assertFileHash(p, "sha256:"+f.SHA256, true); !ok {
+				if !opts.Quiet {
+					fmt.Fprintf(os.Stderr, "Hash mismatch, redownloading: %s\n", p)
+				}
+				if err := os.Remove(p); err != nil {
+					return out, err
+				}
+			}
+		}
+	}
+
+	fopts := FolderOptions{DownloadOptions: opts.DownloadOptions}
+	fopts.Resume = true
+	files, err := DownloadFolder(a.URL, "", dest, fopts)
+	if err != nil {
+		return out, fmt.Errorf("artifact %s: %w", a.Name, err)
+	}
+	for _, p := range files {
+		hashes, size, err := fileHashes(p)
+		if err != nil {
+			return out, fmt.Errorf("artifact %s: %w", a.Name, err)
+		}
+		f := LockedFile{Path: lockedPath(opts.Dir, getCacheRoot(opts.CacheDir), p), Size: size, MD5: hashes["md5"], SHA256: hashes["sha256"]}
+		if locked != nil {
+			e, ok := expected[f.Path]
+			if !ok {
+				return out, fmt.Errorf("artifact %s: %s is not in the lockfile", a.Name, f.Path)
+			}
+			if e.SHA256 != f.SHA256 {
+				return out, fmt.Errorf("artifact %s: %s doesn't match the lockfile", a.Name, f.Path)
+			}
+			delete(expected, f.Path)
+		}
+		out.Files = append(out.Files, f)
+	}
+	for p := range expected {
+		return out, fmt.Errorf("artifact %s: %s is in the lockfile but no longer in the folder", a.Name, p)
+	}
+	sort.Slice(out.Files, func(i, j int) bool { return out.Files[i].Path < out.Files[j].Path })
+	return out, nil
+}
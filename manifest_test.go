@@ -0,0 +1,27 @@
+package gdown
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLockedPath(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "project")
+	cacheRoot := filepath.Join(root, "home", ".cache", "gdown")
+	for _, tt := range []struct {
+		p, want string
+	}{
+		{filepath.Join(dir, "data", "a.csv"), "data/a.csv"},
+		{filepath.Join(root, "shared", "b.csv"), "../shared/b.csv"},
+		{filepath.Join(cacheRoot, "https-COLON--SLASH--SLASH-x"), "$CACHE/https-COLON--SLASH--SLASH-x"},
+	} {
+		got := lockedPath(dir, cacheRoot, tt.p)
+		if got != tt.want {
+			t.Errorf("lockedPath(%s) = %s, want %s", tt.p, got, tt.want)
+		}
+		if back := resolveLockedPath(dir, cacheRoot, got); back != tt.p {
+			t.Errorf("resolveLockedPath(%s) = %s, want %s", got, back, tt.p)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package gdown
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//
+// Metalink (RFC 5854) and aria2 input files
+//
+
+// metalinkNS is the XML namespace of Metalink 4.
+const metalinkNS = "urn:ietf:params:xml:ns:metalink"
+
+type metalinkXML struct {
+	XMLName xml.Name       `xml:"metalink"`
+	Xmlns   string         `xml:"xmlns,attr,omitempty"`
+	Files   []metalinkFile `xml:"file"`
+}
+
+type metalinkFile struct {
+	Name   string         `xml:"name,attr"`
+	Size   int64          `xml:"size,omitempty"`
+	Hashes []metalinkHash `xml:"hash,omitempty"`
+	URLs   []metalinkURL  `xml:"url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkURL struct {
+	Priority int    `xml:"priority,attr,omitempty"`
+	Location string `xml:"location,attr,omitempty"`
+	URL      string `xml:",chardata"`
+}
+
+// hashPreference lists the supported hash algorithms, strongest first, with
+// the names used by Metalink and aria2.
+var hashPreference = []struct{ name, algo string }{
+	{"sha-256", "sha256"},
+	{"sha-1", "sha1"},
+	{"md5", "md5"},
+}
+
+// pickHash returns the strongest supported hash of a name -> value map in
+// the <algo>:<hash_value> format, or an empty string.
+func pickHash(hashes map[string]string) string {
+	for _, h := range hashPreference {
+		if v := hashes[h.name]; v != "" {
+			return h.algo + ":" + strings.ToLower(v)
+		}
+	}
+	return ""
+}
+
+// safeRelPath rejects names that would escape the download directory, as
+// required by RFC 5854.
+func safeRelPath(name string) error {
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(name) {
+		return fmt.Errorf("invalid file name: %q", name)
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("invalid file name: %q", name)
+		}
+	}
+	return nil
+}
+
+// ParseMetalink reads a Metalink 4 document; older versions are rejected.
+// Each file becomes a BatchItem whose URL is the one with the highest
+// priority and whose Mirrors are the rest; the strongest supported hash and
+// the size are kept for verification and preallocation.
+func ParseMetalink(r io.Reader) ([]BatchItem, error) {
+	var doc metalinkXML
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("couldn't parse metalink: %w", err)
+	}
+	if doc.XMLName.Space != metalinkNS {
+		return nil, fmt.Errorf("not a Metalink 4 document: namespace %q", doc.XMLName.Space)
+	}
+	var items []BatchItem
+	for _, f := range doc.Files {
+		if err := safeRelPath(f.Name); err != nil {
+			return nil, fmt.Errorf("metalink: %w", err)
+		}
+		if len(f.URLs) == 0 {
+			return nil, fmt.Errorf("metalink: file %s has no url", f.Name)
+		}
+		// Priority 1 is the highest; URLs without one come last.
+		urls := append([]metalinkURL(nil), f.URLs...)
+		sort.SliceStable(urls, func(i, j int) bool {
+			pi, pj := urls[i].Priority, urls[j].Priority
+			return pi != 0 && (pj == 0 || pi < pj)
+		})
+		hashes := map[string]string{}
+		for _, h := range f.Hashes {
+			hashes[strings.ToLower(h.Type)] = strings.TrimSpace(h.Value)
+		}
+		item := BatchItem{
+			URL:    strings.TrimSpace(urls[0].URL),
+			Output: filepath.FromSlash(f.Name),
+			Hash:   pickHash(hashes),
+			Size:   f.Size,
+		}
+		for _, u := range urls[1:] {
+			item.Mirrors = append(item.Mirrors, strings.TrimSpace(u.URL))
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ParseAria2Input reads an aria2 input file: each download is a line of
+// tab-separated URIs of the same file, followed by indented option lines.
+// The out, dir and checksum options are supported; others are ignored. out
+// and dir have to be relative paths within the download directory.
+func ParseAria2Input(r io.Reader) ([]BatchItem, error) {
+	var items []BatchItem
+	var dir, out string
+	flush := func() {
+		if len(items) == 0 {
+			return
+		}
+		item := &items[len(items)-1]
+		switch {
+		case out != "":
+			item.Output = filepath.Join(dir, out)
+		case dir != "":
+			item.Output = dir + string(filepath.Separator)
+		}
+		dir, out = "", ""
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if raw[0] != ' ' && raw[0] != '\t' {
+			flush()
+			uris := strings.Fields(line)
+			items = append(items, BatchItem{URL: uris[0], Mirrors: uris[1:]})
+			continue
+		}
+		if len(items) == 0 {
+			return nil, fmt.Errorf("line %d: option before any uri", lineNo)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid option %q", lineNo, line)
+		}
+		switch key {
+		case "out":
+			if err := safeRelPath(value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			out = value
+		case "dir":
+			if err := safeRelPath(value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			dir = value
+		case "checksum":
+			algo, sum, ok := strings.Cut(value, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid checksum %q", lineNo, value)
+			}
+			hash := pickHash(map[string]string{strings.ToLower(algo): sum})
+			if hash == "" {
+				return nil, fmt.Errorf("line %d: unsupported checksum type %s", lineNo, algo)
+			}
+			items[len(items)-1].Hash = hash
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return items, nil
+}
+
+// aria2OptionRe matches the indented option lines of aria2 input files.
+var aria2OptionRe = regexp.MustCompile(`(?m)^[ \t]+[a-z0-9-]+=`)
+
+// ParseBatchInput reads a download list in the given format: "list" (see
+// ParseBatchList), "metalink" or "aria2". An empty format detects it from
+// the content.
+func ParseBatchInput(r io.Reader, format string) ([]BatchItem, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		switch trimmed := bytes.TrimSpace(data); {
+		case bytes.HasPrefix(trimmed, []byte("<")):
+			format = "metalink"
+		case aria2OptionRe.Match(data):
+			format = "aria2"
+		default:
+			format = "list"
+		}
+	}
+	switch format {
+	case "list":
+		return ParseBatchList(bytes.NewReader(data))
+	case "metalink":
+		return ParseMetalink(bytes.NewReader(data))
+	case "aria2":
+		return ParseAria2Input(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unknown input format: %s", format)
+	}
+}
+
+// WriteMetalink writes a Metalink 4 document describing the files of a
+// ListFolder result, named by their path within the folder. Only verified
+// sizes are written, since consumers check downloads against them.
+func WriteMetalink(w io.Writer, infos []FileInfo) error {
+	doc := metalinkXML{Xmlns: metalinkNS}
+	for _, info := range infos {
+		if info.IsFolder {
+			continue
+		}
+		file := metalinkFile{
+			Name: filepath.ToSlash(info.Path),
+			URLs: []metalinkURL{{Priority: 1, URL: info.DownloadURL}},
+		}
+		if info.SizeVerified {
+			file.Size = info.Size
+		}
+		if info.MD5Checksum != "" {
+			file.Hashes = []metalinkHash{{Type: "md5", Value: info.MD5Checksum}}
+		}
+		doc.Files = append(doc.Files, file)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,134 @@
+package gdown
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMetalink = `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="data/a.bin">
+    <size>5</size>
+    <hash type="md5">AAAA</hash>
+    <hash type="sha-256">BBBB</hash>
+    <url>https://mirror.example.com/a.bin</url>
+    <url priority="2">https://second.example.com/a.bin</url>
+    <url priority="1">https://first.example.com/a.bin</url>
+  </file>
+</metalink>
+`
+
+func TestParseMetalink(t *testing.T) {
+	items, err := ParseMetalink(strings.NewReader(testMetalink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BatchItem{{
+		URL:     "https://first.example.com/a.bin",
+		Output:  filepath.FromSlash("data/a.bin"),
+		Hash:    "sha256:bbbb",
+		Size:    5,
+		Mirrors: []string{"https://second.example.com/a.bin", "https://mirror.example.com/a.bin"},
+	}}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("items = %+v, want %+v", items, want)
+	}
+}
+
+func TestParseMetalinkRejects(t *testing.T) {
+	for name, doc := range map[string]string{
+		"metalink 3":   `<metalink xmlns="http://www.metalinker.org/" version="3.0"><files><file name="a"/></files></metalink>`,
+		"no namespace": `<metalink><file name="a"><url>https://example.com/a</url></file></metalink>`,
+		"escaping":     `<metalink xmlns="urn:ietf:params:xml:ns:metalink"><file name="../a"><url>https://example.com/a</url></file></metalink>`,
+		"no url":       `<metalink xmlns="urn:ietf:params:xml:ns:metalink"><file name="a"/></metalink>`,
+	} {
+		if items, err := ParseMetalink(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: parsed as %+v", name, items)
+		}
+	}
+}
+
+func TestParseAria2Input(t *testing.T) {
+	input := "# comment\n" +
+		"https://a.example.com/a\thttps://b.example.com/a\n" +
+		"  dir=sub\n" +
+		"  out=a.bin\n" +
+		"  checksum=sha-1=CCCC\n" +
+		"  max-connection-per-server=4\n" +
+		"https://example.com/b\n" +
+		"\tdir=other\n" +
+		"https://example.com/c\n"
+	items, err := ParseAria2Input(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BatchItem{
+		{URL: "https://a.example.com/a", Mirrors: []string{"https://b.example.com/a"}, Output: filepath.Join("sub", "a.bin"), Hash: "sha1:cccc"},
+		{URL: "https://example.com/b", Mirrors: []string{}, Output: "other" + string(filepath.Separator)},
+		{URL: "https://example.com/c", Mirrors: []string{}},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("items = %+v, want %+v", items, want)
+	}
+
+	for _, opt := range []string{"out=../x", "dir=../../x", "dir=/tmp", "out=/etc/passwd", "checksum=crc32=1", "checksum"} {
+		if items, err := ParseAria2Input(strings.NewReader("https://example.com/a\n  " + opt + "\n")); err == nil {
+			t.Errorf("%s: parsed as %+v", opt, items)
+		}
+	}
+}
+
+func TestParseBatchInputDetectsFormat(t *testing.T) {
+	for name, tt := range map[string]struct {
+		input string
+		want  BatchItem
+	}{
+		"metalink": {testMetalink, BatchItem{URL: "https://first.example.com/a.bin"}},
+		"aria2":    {"https://example.com/a\n  out=a.bin\n", BatchItem{URL: "https://example.com/a", Output: "a.bin"}},
+		"list":     {"https://example.com/a a.bin - pdf\n", BatchItem{URL: "https://example.com/a", Output: "a.bin", Format: "pdf"}},
+		"jsonl":    {`{"url": "https://example.com/a", "output": "a.bin"}` + "\n", BatchItem{URL: "https://example.com/a", Output: "a.bin"}},
+	} {
+		items, err := ParseBatchInput(strings.NewReader(tt.input), "")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(items) != 1 {
+			t.Errorf("%s: %d items", name, len(items))
+			continue
+		}
+		got := items[0]
+		if got.URL != tt.want.URL || (tt.want.Output != "" && got.Output != tt.want.Output) || got.Format != tt.want.Format {
+			t.Errorf("%s: item = %+v, want %+v", name, got, tt.want)
+		}
+	}
+	if _, err := ParseBatchInput(strings.NewReader("https://example.com/a\n"), "csv"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestWriteMetalinkRoundTrip(t *testing.T) {
+	infos := []FileInfo{
+		{Path: "root", IsFolder: true},
+		{Path: filepath.Join("root", "a.txt"), DownloadURL: "https://example.com/a", Size: 5, SizeVerified: true, MD5Checksum: "abcd"},
+		{Path: filepath.Join("root", "b.txt"), DownloadURL: "https://example.com/b", Size: 1000},
+	}
+	var buf bytes.Buffer
+	if err := WriteMetalink(&buf, infos); err != nil {
+		t.Fatal(err)
+	}
+	items, err := ParseMetalink(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BatchItem{
+		{URL: "https://example.com/a", Output: filepath.Join("root", "a.txt"), Hash: "md5:abcd", Size: 5},
+		{URL: "https://example.com/b", Output: filepath.Join("root", "b.txt")},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("round trip = %+v, want %+v", items, want)
+	}
+}
@@ -0,0 +1,225 @@
+package gdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+//
+// Mirror fallback – several source URLs for the same file
+//
+
+// MirrorOptions holds settings for DownloadMirrors.
+type MirrorOptions struct {
+	DownloadOptions
+	Race bool // download from every source at once and keep the first verified result
+}
+
+// MirrorResult is the outcome of DownloadMirrors.
+type MirrorResult struct {
+	Path   string
+	Source string // the URL that served the file
+}
+
+// DownloadMirrors downloads a file that is available from several sources
+// (e.g. a Google Drive link and an HTTP or S3 mirror). Sources are tried in
+// order until one succeeds and matches hash (in the format
+// <algo>:<hash_value>, empty to skip verification); with opts.Race they are
+// all started at once and the first verified download wins.
+func DownloadMirrors(sources []string, output, hash string, opts MirrorOptions) (*MirrorResult, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no source urls")
+	}
+	if isOffline(opts.DownloadOptions) {
+		p, src, err := offlineSources(sources, output, hash, opts.DownloadOptions)
+		if err != nil {
+			return nil, err
+		}
+		return &MirrorResult{Path: p, Source: src}, nil
+	}
+	client, err := newHTTPClient(opts.DownloadOptions)
+	if err != nil {
+		return nil, err
+	}
+	dl, src, err := downloadSources(client, sources, output, hash, opts.DownloadOptions, nil, opts.Race)
+	if err != nil {
+		return nil, err
+	}
+	return &MirrorResult{Path: dl.Path, Source: src}, nil
+}
+
+// verifySource checks a downloaded file against hash, removing it if it
+// doesn't match.
+func verifySource(p, hash string) (bool, error) {
+	if hash == "" {
+		return true, nil
+	}
+	ok, err := assertFileHash(p, hash, true)
+	if !ok {
+		_ = os.Remove(p)
+		if err == nil {
+			err = fmt.Errorf("hash mismatch for file %s", p)
+		}
+	}
+	return ok, err
+}
+
+// offlineSources copies the first cached source that matches hash to
+// output and returns its path and URL.
+func offlineSources(sources []string, output, hash string, opts DownloadOptions) (string, string, error) {
+	var errs []error
+	for _, src := range sources {
+		p, err := offlineDownload(src, output, opts)
+		if err == nil {
+			_, err = verifySource(p, hash)
+		}
+		if err == nil {
+			return p, src, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", "", errors.Join(errs...)
+}
+
+// downloadSources downloads output from the first source that succeeds and
+// matches hash, and returns it with the URL that served it. cond and
+// opts.Resume only apply to the first source, since validators and partial
+// files aren't shared between servers.
+func downloadSources(client *http.Client, sources []string, output, hash string, opts DownloadOptions, cond *Validators, race bool) (*downloadResult, string, error) {
+	if race && len(sources) > 1 && cond == nil {
+		return raceSources(client, sources, output, hash, opts)
+	}
+	var errs []error
+	for i, src := range sources {
+		c, sopts := cond, opts
+		if i > 0 {
+			c = nil
+			// A partial file left by another source can't be continued
+			// with this one's bytes.
+			sopts.Resume = false
+		}
+		dl, err := downloadWithClient(client, src, output, sopts, c)
+		if err == nil && !dl.NotModified {
+			_, err = verifySource(dl.Path, hash)
+		}
+		if err == nil {
+			return dl, src, nil
+		}
+		if len(sources) > 1 {
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Source failed: %s: %v\n", src, err)
+			}
+			err = fmt.Errorf("%s: %w", src, err)
+		}
+		errs = append(errs, err)
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// contextTransport attaches a context to every request so that losing
+// downloads of a race can be cancelled.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// raceSources downloads every source at once into temporary directories next
+// to output and keeps the first download that matches hash.
+func raceSources(client *http.Client, sources []string, output, hash string, opts DownloadOptions) (*downloadResult, string, error) {
+	// Temporary downloads can't be resumed.
+	opts.Resume = false
+	dir := filepath.Dir(output)
+	isDir := false
+	if output == "" {
+		dir = "."
+	} else if fi, err := os.Stat(output); err == nil && fi.IsDir() {
+		dir, isDir = output, true
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type outcome struct {
+		src    string
+		dl     *downloadResult
+		tmpDir string
+		err    error
+	}
+	ch := make(chan outcome, len(sources))
+	for _, src := range sources {
+		go func(src string) {
+			tmpDir, err := os.MkdirTemp(dir, ".gdown-mirror")
+			if err != nil {
+				ch <- outcome{src: src, err: err}
+				return
+			}
+			target := tmpDir // a directory takes the file name sent by the server
+			switch {
+			case output == "":
+				u, err := url.Parse(src)
+				if err != nil {
+					ch <- outcome{src: src, tmpDir: tmpDir, err: err}
+					return
+				}
+				target = filepath.Join(tmpDir, path.Base(u.Path))
+			case !isDir:
+				target = filepath.Join(tmpDir, "dl")
+			}
+			c := *client
+			c.Transport = &contextTransport{ctx: ctx, base: base}
+			dl, err := downloadWithClient(&c, src, target, opts, nil)
+			if err == nil {
+				_, err = verifySource(dl.Path, hash)
+			}
+			ch <- outcome{src: src, dl: dl, tmpDir: tmpDir, err: err}
+		}(src)
+	}
+
+	var winner *outcome
+	var errs []error
+	for range sources {
+		o := <-ch
+		if o.err == nil && winner == nil {
+			winner = &o
+			cancel()
+			continue
+		}
+		if o.tmpDir != "" {
+			os.RemoveAll(o.tmpDir)
+		}
+		if winner == nil {
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Source failed: %s: %v\n", o.src, o.err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", o.src, o.err))
+		}
+	}
+	if winner == nil {
+		return nil, "", errors.Join(errs...)
+	}
+	defer os.RemoveAll(winner.tmpDir)
+	final := output
+	if output == "" || isDir {
+		final = filepath.Join(dir, filepath.Base(winner.dl.Path))
+	}
+	if err := os.Rename(winner.dl.Path, final); err != nil {
+		return nil, "", err
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Fastest source: %s\n", winner.src)
+	}
+	return &downloadResult{Path: final, Header: winner.dl.Header}, winner.src, nil
+}
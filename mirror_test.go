@@ -0,0 +1,52 @@
+package gdown_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/gdown"
+)
+
+func TestDownloadMirrorsDoesNotResumeAcrossSources(t *testing.T) {
+	primary := bytes.Repeat([]byte("A"), 1000)
+	mirror := bytes.Repeat([]byte("B"), 1000)
+	// The primary source breaks off after half of the file.
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(primary)))
+		w.Write(primary[:500])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer broken.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(mirror))
+	}))
+	defer good.Close()
+
+	output := filepath.Join(t.TempDir(), "file")
+	opts := gdown.MirrorOptions{DownloadOptions: gdown.DownloadOptions{Quiet: true, Resume: true}}
+	res, err := gdown.DownloadMirrors([]string{broken.URL + "/file", good.URL + "/file"}, output, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Source != good.URL+"/file" {
+		t.Fatalf("served by %s", res.Source)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, mirror) {
+		t.Fatalf("output mixes sources: %d bytes starting with %q", len(data), data[:10])
+	}
+}
@@ -0,0 +1,224 @@
+package gdown
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Offline mode – serve from cache and never touch the network
+//
+
+// ErrOffline is wrapped by errors returned when offline mode prevents a
+// download.
+var ErrOffline = errors.New("offline mode")
+
+// OfflineError lists the URLs or IDs that were missing from the cache while
+// running in offline mode.
+type OfflineError struct {
+	Missing []string
+}
+
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("offline mode: %d item(s) missing from cache: %s", len(e.Missing), strings.Join(e.Missing, ", "))
+}
+
+func (e *OfflineError) Unwrap() error {
+	return ErrOffline
+}
+
+// isOffline reports whether opts or the GDOWN_OFFLINE environment variable
+// enable offline mode.
+func isOffline(opts DownloadOptions) bool {
+	if opts.Offline {
+		return true
+	}
+	v, err := strconv.ParseBool(os.Getenv("GDOWN_OFFLINE"))
+	return err == nil && v
+}
+
+// cacheDirs returns the writable cache directory followed by the read-only
+// ones configured in opts.
+func cacheDirs(opts DownloadOptions) []string {
+	return append([]string{getCacheRoot(opts.CacheDir)}, getReadOnlyCacheDirs(opts.ReadOnlyDirs)...)
+}
+
+// sameSource reports whether two URLs refer to the same file, either
+// literally or by Google Drive file ID.
+func sameSource(a, b string) bool {
+	if a == b {
+		return true
+	}
+	idA, _, errA := ParseUrl(a, false)
+	idB, _, errB := ParseUrl(b, false)
+	return errA == nil && errB == nil && idA != "" && idA == idB
+}
+
+// findCachedFile returns the cache entry of a cached copy of urlStr from
+// any cache directory, or nil if there is none. Entries are looked up by
+// URL and then by Google Drive file ID in the secondary index of the cache
+// and in the records of files downloaded outside it.
+func findCachedFile(opts DownloadOptions, urlStr string) (*CacheEntry, error) {
+	id, _, _ := ParseUrl(urlStr, false)
+	for _, dir := range cacheDirs(opts) {
+		if p := filepath.Join(dir, cacheFilename(urlStr)); fileExists(p) {
+			entry, _ := readCacheEntry(dir, p)
+			if entry == nil {
+				entry = &CacheEntry{URL: urlStr, Path: p}
+			}
+			return entry, nil
+		}
+		entries, ok := lookupSource(dir, urlStr, id)
+		if !ok {
+			var err error
+			if entries, err = ListCache(dir); err != nil {
+				return nil, err
+			}
+		}
+		local, _ := lookupSource(localRecordsRoot(dir), urlStr, id)
+		entries = append(entries, local...)
+		for _, entry := range entries {
+			if sameSource(entry.URL, urlStr) {
+				return entry, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// lookupSource returns the entries of the index in root referenced by the
+// URL or the Google Drive file ID id. ok is false if the index has no
+// secondary index (see lookupCacheRef).
+func lookupSource(root, urlStr, id string) (entries []*CacheEntry, ok bool) {
+	entries, ok = lookupCacheRef(root, urlRef(urlStr))
+	if ok && id != "" {
+		byID, _ := lookupCacheRef(root, idRef(id))
+		entries = append(entries, byID...)
+	}
+	return entries, ok
+}
+
+// cachedName returns the file name of a cached entry: the name recorded
+// for it or, for files not stored under a URL-derived name, the base name
+// of its path.
+func cachedName(entry *CacheEntry) string {
+	if entry.Name != "" {
+		return entry.Name
+	}
+	if base := filepath.Base(entry.Path); base != cacheFilename(entry.URL) {
+		return base
+	}
+	return "downloaded_file"
+}
+
+// offlineDownload copies the cached copy of urlStr to output, resolving
+// output the same way Download does.
+func offlineDownload(urlStr, output string, opts DownloadOptions) (string, error) {
+	entry, err := findCachedFile(opts, urlStr)
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", &OfflineError{Missing: []string{urlStr}}
+	}
+	if output == "" {
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return "", err
+		}
+		output = path.Base(u.Path)
+	}
+	if fi, err := os.Stat(output); err == nil && fi.IsDir() {
+		output = filepath.Join(output, cachedName(entry))
+	}
+	if !opts.Quiet {
+		fmt.Fprintf(os.Stderr, "Copying cached %s to %s\n", urlStr, output)
+	}
+	if err := copyFile(entry.Path, output); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// localRecordsRoot returns where the records of files downloaded outside
+// the cache in cacheRoot are kept. They have the layout of a cache index of
+// their own, apart from the cache's, so that cache commands never list,
+// verify or delete the user's files.
+func localRecordsRoot(cacheRoot string) string {
+	return filepath.Join(cacheRoot, "local")
+}
+
+// recordFile records a file downloaded outside the cache directory (by
+// DownloadFolder, DownloadBatch or SyncFolder) under urlStr, so that
+// offline and later runs can find it by URL or file ID while it stays on
+// disk. Only known hashes are recorded; the file isn't hashed again.
+func recordFile(opts DownloadOptions, urlStr, localPath string, hashes map[string]string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(localPath); err == nil {
+		localPath = abs
+	}
+	now := time.Now().UTC()
+	return writeCacheEntry(localRecordsRoot(getCacheRoot(opts.CacheDir)), &CacheEntry{
+		URL:        urlStr,
+		Path:       localPath,
+		Name:       filepath.Base(localPath),
+		Size:       info.Size(),
+		Hashes:     hashes,
+		FetchedAt:  now,
+		CheckedAt:  now,
+		AccessedAt: now,
+	})
+}
+
+// folderListingPath returns where the listing of a folder URL is cached.
+func folderListingPath(cacheRoot, urlStr string) string {
+	return filepath.Join(cacheRoot, "folders", cacheFilename(urlStr)+".json")
+}
+
+// saveFolderListing stores a retrieved folder tree so that it can be used
+// in offline mode.
+func saveFolderListing(opts DownloadOptions, urlStr string, gfile *GoogleDriveFile) error {
+	p := folderListingPath(getCacheRoot(opts.CacheDir), urlStr)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(gfile)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// loadFolderListing returns the cached tree of a folder URL.
+func loadFolderListing(opts DownloadOptions, urlStr string) (*GoogleDriveFile, error) {
+	for _, dir := range cacheDirs(opts) {
+		data, err := os.ReadFile(folderListingPath(dir, urlStr))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var gfile GoogleDriveFile
+		if err := json.Unmarshal(data, &gfile); err != nil {
+			return nil, fmt.Errorf("couldn't parse cached folder listing: %w", err)
+		}
+		return &gfile, nil
+	}
+	return nil, &OfflineError{Missing: []string{urlStr}}
+}
@@ -0,0 +1,100 @@
+package gdown_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/igolaizola/gdown"
+	"github.com/igolaizola/gdown/gdowntest"
+)
+
+func TestRemoveCacheKeepsDownloadedFolder(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	defer srv.Install()()
+	srv.AddFolder("", "root", "root")
+	srv.AddFile("root", "a", "a.txt", []byte("alpha"))
+
+	cache, dir := t.TempDir(), t.TempDir()
+	opts := gdown.FolderOptions{DownloadOptions: gdown.DownloadOptions{Quiet: true, CacheDir: cache}}
+	if _, err := gdown.DownloadFolder("", "root", dir+string(os.PathSeparator), opts); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := gdown.ListCache(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("the cache lists downloaded files: %+v", entries[0])
+	}
+	if _, err := gdown.RemoveCache(cache, "*"); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(dir, "root", "a.txt")
+	if _, err := os.Stat(local); err != nil {
+		t.Fatalf("removing the cache deleted a downloaded file: %v", err)
+	}
+
+	// The record still lets offline runs find the file.
+	out := filepath.Join(t.TempDir(), "a.txt")
+	offline := gdown.DownloadOptions{Quiet: true, CacheDir: cache, Offline: true}
+	if _, err := gdown.Download(gdown.FileDownloadURL("a", ""), out, offline); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachedDownloadOffline(t *testing.T) {
+	srv := newServer(t)
+	opts := gdown.CacheOptions{DownloadOptions: options(t, gdown.DownloadOptions{})}
+	urlStr := gdown.FileDownloadURL("a", "")
+	res, err := gdown.CachedDownloadWithOptions(urlStr, "", "", nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != gdown.CacheMiss {
+		t.Fatalf("first download: %s", res.Status)
+	}
+
+	srv.Remove("a")
+	opts.Offline = true
+	requests := len(srv.Requests())
+	res, err = gdown.CachedDownloadWithOptions(urlStr, "", "", nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != gdown.CacheHit || readFile(t, res.Path) != "alpha" {
+		t.Errorf("offline download: %s, %s", res.Status, res.Path)
+	}
+	output := filepath.Join(t.TempDir(), "a.txt")
+	if _, err := gdown.Download(urlStr, output, opts.DownloadOptions); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, output); got != "alpha" {
+		t.Errorf("offline copy holds %q", got)
+	}
+	if _, err := gdown.Download(gdown.FileDownloadURL("b", ""), output, opts.DownloadOptions); err == nil {
+		t.Error("offline download of an uncached file succeeded")
+	}
+	if n := len(srv.Requests()); n != requests {
+		t.Errorf("%d requests in offline mode", n-requests)
+	}
+}
+
+func TestDownloadFolderOffline(t *testing.T) {
+	srv := newServer(t)
+	opts := gdown.FolderOptions{DownloadOptions: options(t, gdown.DownloadOptions{})}
+	if _, err := gdown.DownloadFolder("", "root", t.TempDir()+string(os.PathSeparator), opts); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	dir := t.TempDir()
+	opts.Offline = true
+	if _, err := gdown.DownloadFolder("", "root", dir+string(os.PathSeparator), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dir, "root", "sub", "b.txt")); got != "bravo" {
+		t.Errorf("b.txt holds %q offline", got)
+	}
+}
@@ -0,0 +1,146 @@
+package gdown
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+//
+// PlanFolder() – what DownloadFolder would do, without doing it
+//
+
+// PlanAction is what DownloadFolder does with a folder entry.
+type PlanAction string
+
+const (
+	PlanMkdir        PlanAction = "mkdir"         // create a missing directory
+	PlanDownload     PlanAction = "download"      // download a missing file
+	PlanResume       PlanAction = "resume"        // continue a partial download
+	PlanOverwrite    PlanAction = "overwrite"     // download again over an existing file
+	PlanSkipExisting PlanAction = "skip-existing" // keep an existing file
+)
+
+// PlannedAction is a step of a FolderPlan.
+type PlannedAction struct {
+	Action   PlanAction
+	Path     string // destination path
+	Size     int64  // size of the remote file; 0 when unknown
+	Transfer int64  // bytes to download; 0 when unknown or nothing is downloaded
+}
+
+// FolderPlan lists the steps of a folder download.
+type FolderPlan struct {
+	Root    string // local directory of the folder
+	Actions []PlannedAction
+}
+
+// Count returns the number of steps doing action.
+func (p *FolderPlan) Count(action PlanAction) int {
+	n := 0
+	for _, a := range p.Actions {
+		if a.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// TotalBytes returns the number of bytes to download and the number of
+// files to download whose size is unknown.
+func (p *FolderPlan) TotalBytes() (total int64, unknown int) {
+	for _, a := range p.Actions {
+		switch a.Action {
+		case PlanDownload, PlanResume, PlanOverwrite:
+			if a.Transfer > 0 {
+				total += a.Transfer
+			} else {
+				unknown++
+			}
+		}
+	}
+	return total, unknown
+}
+
+// PlanFolder lists what DownloadFolder would do with the same arguments:
+// the directories it would create and, for each file, whether it would be
+// downloaded, resumed, overwritten or kept. The folder is listed from Drive
+// but nothing is written, not even the cached listing or the cookies file.
+// Existing files are checked like DownloadFolder checks them, hashing them
+// when Drive reports an MD5 checksum.
+func PlanFolder(urlStr, id, output string, opts FolderOptions) (*FolderPlan, error) {
+	if (id == "" && urlStr == "") || (id != "" && urlStr != "") {
+		return nil, fmt.Errorf("either url or id must be specified")
+	}
+	if opts.SaveCookies {
+		// Cookies are still used for the session, but never written; a
+		// cookies file that doesn't exist yet has nothing to load.
+		if !fileExists(opts.CookiesFile) {
+			opts.CookiesFile, opts.UseCookies = "", true
+		}
+		opts.SaveCookies = false
+	}
+	if id != "" {
+		urlStr = FolderURL(id, "")
+	}
+	gfile, err := retrieveFolder(urlStr, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	plan := &FolderPlan{Root: folderRoot(output, gfile)}
+	if _, err := os.Stat(plan.Root); err != nil {
+		plan.Actions = append(plan.Actions, PlannedAction{Action: PlanMkdir, Path: plan.Root})
+	}
+	for _, f := range getDirectoryStructure(gfile, "", opts) {
+		localPath := filepath.Join(plan.Root, f.Path)
+		action, _ := planEntry(f, localPath, opts.DownloadOptions)
+		if action == "" {
+			continue
+		}
+		step := PlannedAction{Action: action, Path: localPath, Size: f.Size}
+		switch action {
+		case PlanDownload, PlanOverwrite:
+			step.Transfer = f.Size
+		case PlanResume:
+			if fi, err := os.Stat(localPath); err == nil {
+				step.Transfer = f.Size - fi.Size()
+			}
+		}
+		plan.Actions = append(plan.Actions, step)
+	}
+	return plan, nil
+}
+
+// planEntry decides what DownloadFolder does with a folder entry (nothing
+// for existing directories) and returns the options to download it with.
+func planEntry(f FileToDownload, localPath string, opts DownloadOptions) (PlanAction, DownloadOptions) {
+	fi, err := os.Stat(localPath)
+	if f.ID == "" { // folder
+		if err == nil {
+			return "", opts
+		}
+		return PlanMkdir, opts
+	}
+	if err != nil {
+		return PlanDownload, opts
+	}
+	if upToDate(localPath, f) {
+		return PlanSkipExisting, opts
+	}
+	if offline := isOffline(opts); opts.Resume || offline {
+		switch {
+		case offline || f.Size <= 0:
+			return PlanSkipExisting, opts
+		case !f.SizeVerified:
+			// A size read from the folder page can't tell a partial
+			// download from a complete one.
+		case fi.Size() == f.Size:
+			return PlanSkipExisting, opts
+		case fi.Size() < f.Size:
+			return PlanResume, opts
+		}
+		// Not a partial download of the current version.
+		opts.Resume = false
+	}
+	return PlanOverwrite, opts
+}
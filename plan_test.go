@@ -0,0 +1,118 @@
+package gdown_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/gdown"
+	"github.com/igolaizola/gdown/gdowntest"
+)
+
+func TestPlanFolderHashesFilesWithoutSavingCookies(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	defer srv.Install()()
+	srv.AddFolder("", "root", "root")
+	file := srv.AddFile("root", "file", "a.txt", []byte("hello"))
+	file.ModTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler := srv.Config.Handler
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "x"})
+		handler.ServeHTTP(w, r)
+	})
+
+	dir := t.TempDir()
+	opts := gdown.FolderOptions{DownloadOptions: gdown.DownloadOptions{Quiet: true, APIKey: "test-key", CacheDir: t.TempDir()}}
+	if _, err := gdown.DownloadFolder("", "root", dir+string(os.PathSeparator), opts); err != nil {
+		t.Fatal(err)
+	}
+	// Same size and time, different content: DownloadFolder hashes the
+	// file and downloads it again, so the plan must say so.
+	local := filepath.Join(dir, "root", "a.txt")
+	if err := os.WriteFile(local, []byte("HELLO"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(local, file.ModTime, file.ModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := filepath.Join(t.TempDir(), "cookies.txt")
+	opts.CookiesFile, opts.SaveCookies = cookies, true
+	plan, err := gdown.PlanFolder("", "root", dir+string(os.PathSeparator), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Actions) != 1 || plan.Actions[0].Action != gdown.PlanOverwrite {
+		t.Errorf("plan = %+v, want a.txt overwritten", plan.Actions)
+	}
+	if _, err := os.Stat(cookies); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the cookies file: %v", err)
+	}
+}
+
+func TestPlanFolder(t *testing.T) {
+	newServer(t)
+	dir := t.TempDir()
+	opts := gdown.FolderOptions{DownloadOptions: options(t, backends["api"])}
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	a := filepath.Join(root, "a.txt")
+	if err := os.WriteFile(a, []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(a, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := gdown.PlanFolder("", "root", dir+string(os.PathSeparator), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for action, want := range map[gdown.PlanAction]int{gdown.PlanSkipExisting: 1, gdown.PlanMkdir: 1, gdown.PlanDownload: 3} {
+		if n := plan.Count(action); n != want {
+			t.Errorf("%d %s, want %d: %+v", n, action, want, plan.Actions)
+		}
+	}
+	if total, unknown := plan.TotalBytes(); unknown != 1 || total != int64(len("bravo")+len("target")) {
+		t.Errorf("total bytes = %d with %d unknown", total, unknown)
+	}
+	if _, err := os.Stat(filepath.Join(root, "sub")); !os.IsNotExist(err) {
+		t.Errorf("the plan created sub: %v", err)
+	}
+}
+
+func TestPlanFolderDoesNotResumeScrapedSize(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	defer srv.Install()()
+	srv.AddFolder("", "root", "root")
+	srv.AddFile("root", "file", "a.txt", []byte("hello")).ListedSize = 1000
+
+	dir := t.TempDir()
+	local := filepath.Join(dir, "root", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(local), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, []byte("XX"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := gdown.FolderOptions{DownloadOptions: gdown.DownloadOptions{Quiet: true, Resume: true, CacheDir: t.TempDir()}}
+	plan, err := gdown.PlanFolder("", "root", dir+string(os.PathSeparator), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Actions) != 1 || plan.Actions[0].Action != gdown.PlanOverwrite {
+		t.Errorf("plan = %+v, want a.txt overwritten", plan.Actions)
+	}
+	if _, err := gdown.DownloadFolder("", "root", dir+string(os.PathSeparator), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, local); got != "hello" {
+		t.Errorf("a.txt holds %q, want a full download", got)
+	}
+}
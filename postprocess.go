@@ -0,0 +1,199 @@
+package gdown
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//
+// Built-in postprocessors for CachedDownloadWithOptions
+//
+
+// Postprocessor is a step applied to a file returned by
+// CachedDownloadWithOptions. Named postprocessors are recorded in the cache
+// index once they succeed and aren't repeated on later cache hits; unnamed
+// ones only run after a fresh download.
+type Postprocessor struct {
+	Name        string
+	Func        func(path string) error
+	RemovesFile bool // the step deletes the cached file (e.g. after extracting it)
+	InPlace     bool // the step modifies the cached file itself, so it gets a private copy first
+
+	// run is Func for the built-in steps, given the directory their output
+	// goes to when no destination was set ("" for the file's directory).
+	run func(path, dir string) error
+}
+
+// apply runs the step on p, writing default output into dir.
+func (pp *Postprocessor) apply(p, dir string) error {
+	if pp.run != nil {
+		return pp.run(p, dir)
+	}
+	return pp.Func(p)
+}
+
+// postprocessDir returns where the output of a step without a destination
+// goes: a directory of its own for files in the cache root, so extracted
+// files aren't mistaken for cache entries, and the file's directory
+// otherwise.
+func postprocessDir(cacheRoot, p string) string {
+	if !isWithin(cacheRoot, p) {
+		return ""
+	}
+	return filepath.Join(cacheRoot, "extracted", filepath.Base(p))
+}
+
+// ExtractPostprocessor extracts the file with ExtractAll into to. If to is
+// empty, files in the cache are extracted into extracted/<name> under the
+// cache root and other files into their own directory.
+func ExtractPostprocessor(to string) *Postprocessor {
+	pp := &Postprocessor{
+		Name: "extract:" + to,
+		run: func(p, dir string) error {
+			_, err := ExtractAll(p, outputDir(to, dir))
+			return err
+		},
+	}
+	pp.Func = func(p string) error { return pp.run(p, "") }
+	return pp
+}
+
+// ExtractAndDeletePostprocessor extracts the file into to, checks that
+// every extracted file exists and then deletes the archive.
+// The default for an empty to is the same as for ExtractPostprocessor.
+func ExtractAndDeletePostprocessor(to string) *Postprocessor {
+	pp := &Postprocessor{
+		Name:        "extract-delete:" + to,
+		RemovesFile: true,
+		run: func(p, dir string) error {
+			files, err := ExtractAll(p, outputDir(to, dir))
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if !fileExists(f) {
+					return fmt.Errorf("extracted file is missing: %s", f)
+				}
+			}
+			return os.Remove(p)
+		},
+	}
+	pp.Func = func(p string) error { return pp.run(p, "") }
+	return pp
+}
+
+// DecompressPostprocessor decompresses a gzip or bzip2 file into dest. If
+// dest is empty, the .gz/.bz2 extension is dropped or, without one,
+// ".decompressed" is appended; files in the cache are decompressed into
+// extracted/<name> under the cache root.
+func DecompressPostprocessor(dest string) *Postprocessor {
+	pp := &Postprocessor{
+		Name: "decompress:" + dest,
+		run: func(p, dir string) error {
+			_, err := decompressFile(p, dest, dir)
+			return err
+		},
+	}
+	pp.Func = func(p string) error { return pp.run(p, "") }
+	return pp
+}
+
+// outputDir returns to, or dir if to is empty.
+func outputDir(to, dir string) string {
+	if to != "" {
+		return to
+	}
+	return dir
+}
+
+// ChmodExecPostprocessor makes the file executable (chmod +x).
+func ChmodExecPostprocessor() *Postprocessor {
+	return &Postprocessor{
+		Name:    "chmod+x",
+		InPlace: true,
+		Func: func(p string) error {
+			info, err := os.Stat(p)
+			if err != nil {
+				return err
+			}
+			return os.Chmod(p, info.Mode()|0111)
+		},
+	}
+}
+
+// ParsePostprocessor returns the built-in postprocessor described by spec:
+// "extract[:DIR]", "extract-delete[:DIR]", "decompress[:DEST]" or "chmod+x".
+func ParsePostprocessor(spec string) (*Postprocessor, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "extract":
+		return ExtractPostprocessor(arg), nil
+	case "extract-delete":
+		return ExtractAndDeletePostprocessor(arg), nil
+	case "decompress":
+		return DecompressPostprocessor(arg), nil
+	case "chmod+x":
+		return ChmodExecPostprocessor(), nil
+	default:
+		return nil, fmt.Errorf("unknown postprocessor: %s", spec)
+	}
+}
+
+// decompressFile decompresses src (gzip or bzip2, detected from its content)
+// into dest and returns dest. An empty dest is derived from src and placed
+// in dir, or next to src if dir is empty.
+func decompressFile(src, dest, dir string) (string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	magic := make([]byte, 3)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return "", fmt.Errorf("couldn't read %s: %w", src, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	var r io.Reader
+	var ext string
+	switch {
+	case magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		r, ext = gz, ".gz"
+	case string(magic) == "BZh":
+		r, ext = bzip2.NewReader(f), ".bz2"
+	default:
+		return "", fmt.Errorf("unsupported compression format: %s", src)
+	}
+	if dest == "" {
+		dest = strings.TrimSuffix(src, ext)
+		if dest == src {
+			dest = src + ".decompressed"
+		}
+		if dir != "" {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return "", err
+			}
+			dest = filepath.Join(dir, filepath.Base(dest))
+		}
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return "", err
+	}
+	return dest, out.Close()
+}
@@ -0,0 +1,150 @@
+package gdown
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarGz returns a gzipped tar holding a single file.
+func tarGz(t *testing.T, name, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(content))
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestArchiveFormatGzip(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	os.WriteFile(archive, tarGz(t, "a.txt", "hello"), 0644)
+	if got := archiveFormat(archive); got != "tar.gz" {
+		t.Errorf("tar.gz detected as %q", got)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte("just some compressed text"))
+	gz.Close()
+	plain := filepath.Join(dir, "plain")
+	os.WriteFile(plain, buf.Bytes(), 0644)
+	if got := archiveFormat(plain); got != "" {
+		t.Errorf("plain gzip detected as %q", got)
+	}
+}
+
+func TestExtractAndDelete(t *testing.T) {
+	data := tarGz(t, "a.txt", "hello")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+	opts := CacheOptions{DownloadOptions: DownloadOptions{Quiet: true, CacheDir: cacheDir}}
+
+	// A copy that changed since it was cached isn't consumed.
+	res, err := CachedDownloadWithOptions(srv.URL+"/tampered", "", "", nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(res.Path, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	to := t.TempDir()
+	if _, err := CachedDownloadWithOptions(srv.URL+"/tampered", "", "", ExtractAndDeletePostprocessor(to), opts); err == nil {
+		t.Fatal("tampered archive was extracted")
+	}
+	if !fileExists(res.Path) {
+		t.Fatal("tampered archive was deleted")
+	}
+
+	// A good one is extracted and deleted, and gc keeps the record of it.
+	res, err = CachedDownloadWithOptions(srv.URL+"/good", "", "", ExtractAndDeletePostprocessor(to), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(res.Path) || !fileExists(filepath.Join(to, "a.txt")) {
+		t.Fatal("archive wasn't extracted and deleted")
+	}
+	if _, _, err := GCCache(cacheDir, CacheGCOptions{MaxSize: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	res, err = CachedDownloadWithOptions(srv.URL+"/good", "", "", ExtractAndDeletePostprocessor(to), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != CacheHit || fileExists(res.Path) {
+		t.Fatalf("archive fetched again after gc: %s", res.Status)
+	}
+}
+
+func TestExtractDefaultsOutsideCacheRoot(t *testing.T) {
+	data := tarGz(t, "a.txt", "hello")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	}))
+	defer srv.Close()
+	cacheDir := t.TempDir()
+	opts := CacheOptions{DownloadOptions: DownloadOptions{Quiet: true, CacheDir: cacheDir}}
+	res, err := CachedDownloadWithOptions(srv.URL+"/archive.tar.gz", "", "", ExtractPostprocessor(""), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(filepath.Join(cacheDir, "extracted", filepath.Base(res.Path), "a.txt")) {
+		t.Fatal("archive wasn't extracted into its own directory")
+	}
+	entries, err := ListCache(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != res.Path {
+		t.Errorf("cache entries = %+v, want only the archive", entries)
+	}
+}
+
+func TestExtractAllRejectsEscapingEntries(t *testing.T) {
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	w, _ := zw.Create("../evil")
+	w.Write([]byte("evil"))
+	zw.Close()
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	tw.WriteHeader(&tar.Header{Name: "link", Linkname: "../evil", Typeflag: tar.TypeSymlink})
+	tw.Close()
+
+	for name, data := range map[string][]byte{
+		"slip.zip":    zipBuf.Bytes(),
+		"slip.tar.gz": tarGz(t, "../evil", "evil"),
+		"symlink.tar": tarBuf.Bytes(),
+	} {
+		dir := t.TempDir()
+		archive := filepath.Join(dir, name)
+		if err := os.WriteFile(archive, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		to := filepath.Join(dir, "out")
+		if _, err := ExtractAll(archive, to); err == nil {
+			t.Errorf("%s: escaping entry was extracted", name)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+			t.Errorf("%s: file written outside the destination", name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package gdown
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//
+// Drive link extraction from free text
+//
+
+// ScanMatch is a Google Drive link or bare ID found in text.
+type ScanMatch struct {
+	ParsedURL
+	Text string // the link or ID as it appeared in the text
+}
+
+var (
+	// scanWordRe matches runs of ID characters; bare IDs are the runs of 33
+	// characters (current items) or 44 (older ones).
+	scanWordRe = regexp.MustCompile(`[A-Za-z0-9_-]+`)
+
+	scanLinkMu    sync.Mutex
+	scanLinkHosts string
+	scanLinkRe    *regexp.Regexp
+)
+
+// scanLinkRegexp returns a pattern matching links on the Google Drive hosts,
+// public or configured through the base URLs, up to whitespace, quotes,
+// brackets or the '|' of Slack-style <url|label> links. It is rebuilt when
+// the base URLs change.
+func scanLinkRegexp() *regexp.Regexp {
+	hosts := map[string]bool{}
+	for h := range driveHosts {
+		hosts[h] = true
+	}
+	for _, base := range []string{DriveBaseURL, DocsBaseURL, UserContentBaseURL} {
+		if b, err := url.Parse(base); err == nil && b.Host != "" {
+			hosts[b.Host] = true
+		}
+	}
+	var quoted []string
+	for h := range hosts {
+		quoted = append(quoted, regexp.QuoteMeta(h))
+	}
+	// Longer hosts first so that a host isn't cut short by its prefix.
+	sort.Slice(quoted, func(i, j int) bool {
+		if len(quoted[i]) != len(quoted[j]) {
+			return len(quoted[i]) > len(quoted[j])
+		}
+		return quoted[i] < quoted[j]
+	})
+	key := strings.Join(quoted, "|")
+	scanLinkMu.Lock()
+	defer scanLinkMu.Unlock()
+	if scanLinkRe == nil || scanLinkHosts != key {
+		scanLinkRe = regexp.MustCompile(`https?://(?:` + key + `)/[^\s"'<>()\[\]{}|\\]+`)
+		scanLinkHosts = key
+	}
+	return scanLinkRe
+}
+
+// ScanText returns every Google Drive link and bare item ID found in text,
+// classified with ClassifyUrl and de-duplicated by ID in order of first
+// appearance. Bare IDs have an empty Kind since it can't be determined.
+func ScanText(text string) []ScanMatch {
+	var matches []ScanMatch
+	seen := map[string]bool{}
+	linkRe := scanLinkRegexp()
+	for _, link := range linkRe.FindAllString(text, -1) {
+		link = strings.TrimRight(link, ".,;:!?*`")
+		p, err := ClassifyUrl(link)
+		if err != nil || p == nil || p.ID == "" || seen[p.ID] {
+			continue
+		}
+		seen[p.ID] = true
+		matches = append(matches, ScanMatch{ParsedURL: *p, Text: link})
+	}
+	// Links are blanked out so that their IDs aren't reported twice.
+	rest := linkRe.ReplaceAllString(text, " ")
+	for _, id := range scanWordRe.FindAllString(rest, -1) {
+		if (len(id) != 33 && len(id) != 44) || seen[id] || !looksLikeID(id) {
+			continue
+		}
+		seen[id] = true
+		matches = append(matches, ScanMatch{ParsedURL: ParsedURL{ID: id, AccountIndex: -1}, Text: id})
+	}
+	return matches
+}
+
+// ScanReader reads r to the end and returns the matches of ScanText.
+func ScanReader(r io.Reader) ([]ScanMatch, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ScanText(string(data)), nil
+}
+
+// looksLikeID filters out runs of word characters that are unlikely to be
+// Drive IDs, such as all-lowercase identifiers or plain numbers.
+func looksLikeID(s string) bool {
+	var upper, lower, digit bool
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
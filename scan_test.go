@@ -0,0 +1,32 @@
+package gdown_test
+
+import (
+	"testing"
+
+	"github.com/igolaizola/gdown"
+	"github.com/igolaizola/gdown/gdowntest"
+)
+
+func TestScanTextConfiguredHosts(t *testing.T) {
+	s := gdowntest.NewServer()
+	defer s.Close()
+	defer s.Install()()
+
+	text := "fixture " + s.URL + "/file/d/1AbCdEfGhIjKlMnOpQrStUvWxYz012345/view, public <https://drive.google.com/drive/folders/1ZyXwVuTsRqPoNmLkJiHgFeDcBa987654|folder> and https://example.com/other"
+	matches := gdown.ScanText(text)
+	want := []struct {
+		id   string
+		kind gdown.URLKind
+	}{
+		{"1AbCdEfGhIjKlMnOpQrStUvWxYz012345", gdown.KindFile},
+		{"1ZyXwVuTsRqPoNmLkJiHgFeDcBa987654", gdown.KindFolder},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("got %d matches, want %d: %+v", len(matches), len(want), matches)
+	}
+	for i, w := range want {
+		if matches[i].ID != w.id || matches[i].Kind != w.kind {
+			t.Errorf("match %d: got %s %s, want %s %s", i, matches[i].ID, matches[i].Kind, w.id, w.kind)
+		}
+	}
+}
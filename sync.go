@@ -0,0 +1,258 @@
+package gdown
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//
+// SyncFolder() – keeps a local copy of a folder up to date
+//
+
+// SyncOptions holds settings for SyncFolder.
+type SyncOptions struct {
+	FolderOptions
+	Delete     bool   // remove local files that are no longer in the folder
+	Quarantine string // move local files that are no longer in the folder to this directory instead
+}
+
+// SyncAction is what SyncFolder did with a file.
+type SyncAction string
+
+const (
+	SyncAdded       SyncAction = "added"       // new remote file downloaded
+	SyncUpdated     SyncAction = "updated"     // changed remote file downloaded again
+	SyncUnchanged   SyncAction = "unchanged"   // local copy matches the remote file
+	SyncExtra       SyncAction = "extra"       // local file not in the folder, kept
+	SyncDeleted     SyncAction = "deleted"     // local file not in the folder, removed
+	SyncQuarantined SyncAction = "quarantined" // local file not in the folder, moved to the quarantine directory
+	SyncFailed      SyncAction = "failed"
+)
+
+// SyncChange is the outcome of SyncFolder for one file.
+type SyncChange struct {
+	Path   string // relative path within the folder
+	Action SyncAction
+	Err    error // set when Action is SyncFailed
+}
+
+// SyncResult lists what SyncFolder did, by path.
+type SyncResult struct {
+	Root    string // local directory of the folder
+	Changes []SyncChange
+}
+
+// Count returns the number of files that got action.
+func (r *SyncResult) Count(action SyncAction) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary describes the changes in one line, e.g. "2 added, 1 updated,
+// 10 unchanged".
+func (r *SyncResult) Summary() string {
+	var parts []string
+	for _, action := range []SyncAction{SyncAdded, SyncUpdated, SyncUnchanged, SyncExtra, SyncDeleted, SyncQuarantined, SyncFailed} {
+		if n := r.Count(action); n > 0 || action == SyncUnchanged {
+			parts = append(parts, fmt.Sprintf("%d %s", n, action))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SyncFolder makes output (see DownloadFolder) mirror a Google Drive
+// folder. Files are compared with their size, MD5 and modification time as
+// reported by Drive; new and changed ones are downloaded and unchanged ones
+// kept. Files that exist locally but not in the folder are reported, and
+// removed with opts.Delete or moved with opts.Quarantine. Local files
+// without remote metadata to compare with are kept as they are.
+//
+// Changed files are downloaded next to the local copy and only replace it
+// once they pass the checks. Items left out of the folder's listing, such
+// as forms and skipped shortcuts, aren't extras: their local paths are left
+// alone. Failed downloads don't stop the sync; they are reported in the
+// result and as an error.
+func SyncFolder(urlStr, id, output string, opts SyncOptions) (*SyncResult, error) {
+	if (id == "" && urlStr == "") || (id != "" && urlStr != "") {
+		return nil, fmt.Errorf("either url or id must be specified")
+	}
+	if id != "" {
+		urlStr = FolderURL(id, "")
+	}
+	gfile, err := retrieveFolder(urlStr, opts.FolderOptions, true)
+	if err != nil {
+		return nil, err
+	}
+	root := folderRoot(output, gfile)
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, err
+	}
+	quarantine := ""
+	if opts.Quarantine != "" {
+		if quarantine, err = filepath.Abs(opts.Quarantine); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &SyncResult{Root: root}
+	remote := map[string]bool{}
+	var filtered []string
+	failed := 0
+	for _, f := range directoryStructure(gfile, "", opts.FolderOptions, &filtered) {
+		remote[f.Path] = true
+		localPath := filepath.Join(root, f.Path)
+		if f.ID == "" { // folder
+			_ = os.MkdirAll(localPath, os.ModePerm)
+			continue
+		}
+		action := SyncAdded
+		if fi, err := os.Stat(localPath); err == nil && !fi.IsDir() {
+			known := f.Size > 0 || f.MD5Checksum != "" || !f.ModifiedTime.IsZero()
+			if !known || upToDate(localPath, f) {
+				res.Changes = append(res.Changes, SyncChange{Path: f.Path, Action: SyncUnchanged})
+				continue
+			}
+			action = SyncUpdated
+		}
+		dopts := opts.DownloadOptions
+		dopts.Resume = false
+		if action == SyncUpdated {
+			err = replaceFolderFile(f, localPath, dopts)
+		} else {
+			_, err = downloadFolderFile(f, localPath, dopts)
+		}
+		if err != nil {
+			failed++
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Failed to download %s: %v\n", f.Path, err)
+			}
+			res.Changes = append(res.Changes, SyncChange{Path: f.Path, Action: SyncFailed, Err: err})
+			continue
+		}
+		res.Changes = append(res.Changes, SyncChange{Path: f.Path, Action: action})
+	}
+
+	extras, err := localExtras(root, quarantine, remote, filtered)
+	if err != nil {
+		return res, err
+	}
+	for _, rel := range extras {
+		change := SyncChange{Path: rel, Action: SyncExtra}
+		localPath := filepath.Join(root, rel)
+		switch {
+		case quarantine != "":
+			dest := filepath.Join(quarantine, rel)
+			err = os.MkdirAll(filepath.Dir(dest), os.ModePerm)
+			if err == nil {
+				err = os.Rename(localPath, dest)
+			}
+			change.Action = SyncQuarantined
+		case opts.Delete:
+			err = os.Remove(localPath)
+			change.Action = SyncDeleted
+		}
+		if err != nil {
+			failed++
+			change.Action, change.Err = SyncFailed, err
+		}
+		res.Changes = append(res.Changes, change)
+	}
+	if quarantine != "" || opts.Delete {
+		removeEmptyDirs(root, remote, filtered)
+	}
+	sort.SliceStable(res.Changes, func(i, j int) bool { return res.Changes[i].Path < res.Changes[j].Path })
+	if failed > 0 {
+		return res, fmt.Errorf("%d files failed to sync", failed)
+	}
+	return res, nil
+}
+
+// replaceFolderFile downloads a changed folder entry into a temporary
+// directory next to localPath and renames it over the local copy once it
+// passed the checks, so that a failed update keeps the old copy.
+func replaceFolderFile(f FileToDownload, localPath string, opts DownloadOptions) error {
+	tmpDir, err := os.MkdirTemp(filepath.Dir(localPath), ".gdown-sync")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	downloaded, err := fetchFolderFile(f, filepath.Join(tmpDir, filepath.Base(localPath)), opts)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(downloaded, localPath); err != nil {
+		return err
+	}
+	recordFolderFile(opts, f, localPath)
+	return nil
+}
+
+// isFiltered reports whether the local path rel belongs to an item left out
+// of the listing: the item itself, a file inside it (a skipped shortcut to
+// a folder) or its export (a skipped shortcut to a Google-native item).
+func isFiltered(rel string, filtered []string) bool {
+	for _, p := range filtered {
+		if rel == p || strings.HasPrefix(rel, p+string(os.PathSeparator)) {
+			return true
+		}
+		if ext := filepath.Ext(rel); ext != "" && strings.TrimSuffix(rel, ext) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// localExtras returns the files under root (relative to it) that aren't in
+// remote or filtered, leaving out the quarantine directory.
+func localExtras(root, quarantine string, remote map[string]bool, filtered []string) ([]string, error) {
+	var extras []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if abs, err := filepath.Abs(p); err == nil && abs == quarantine {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if !remote[rel] && !isFiltered(rel, filtered) {
+			extras = append(extras, rel)
+		}
+		return nil
+	})
+	return extras, err
+}
+
+// removeEmptyDirs removes the empty directories under root that aren't in
+// remote or filtered, deepest first.
+func removeEmptyDirs(root string, remote map[string]bool, filtered []string) {
+	var dirs []string
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && p != root {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(root, dirs[i])
+		if err != nil || remote[rel] || isFiltered(rel, filtered) {
+			continue
+		}
+		// Fails, as wanted, if the directory isn't empty.
+		_ = os.Remove(dirs[i])
+	}
+}
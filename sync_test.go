@@ -0,0 +1,130 @@
+package gdown_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/gdown"
+	"github.com/igolaizola/gdown/gdowntest"
+)
+
+func TestSyncFolderKeepsOldCopyOnFailedUpdate(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	defer srv.Install()()
+	srv.AddFolder("", "root", "root")
+	file := srv.AddFile("root", "file", "a.txt", []byte("first"))
+	file.ModTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	dir := t.TempDir()
+	opts := gdown.SyncOptions{FolderOptions: gdown.FolderOptions{DownloadOptions: gdown.DownloadOptions{
+		Quiet: true, APIKey: "test-key", CacheDir: t.TempDir(),
+	}}}
+	if _, err := gdown.SyncFolder("", "root", dir+string(os.PathSeparator), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file changes, but its new content arrives corrupted.
+	file.Content = []byte("second")
+	file.ModTime = file.ModTime.Add(time.Hour)
+	handler := srv.Config.Handler
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("alt") == "media" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("corrupted"))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	res, err := gdown.SyncFolder("", "root", dir+string(os.PathSeparator), opts)
+	if err == nil || res.Count(gdown.SyncFailed) != 1 {
+		t.Fatalf("sync of a corrupted update: %v, %s", err, res.Summary())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "root", "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "first" {
+		t.Errorf("a.txt holds %q, want the old copy", data)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d entries left in the folder, want only a.txt", len(entries))
+	}
+}
+
+func TestSyncFolderDeleteKeepsSkippedItems(t *testing.T) {
+	srv := gdowntest.NewServer()
+	defer srv.Close()
+	defer srv.Install()()
+	srv.AddFolder("", "root", "root")
+	srv.AddFile("root", "file", "a.txt", []byte("a"))
+	srv.AddFile("", "target", "b.txt", []byte("b"))
+	srv.AddShortcut("root", "shortcut", "link.txt", "target")
+
+	dir := t.TempDir()
+	opts := gdown.SyncOptions{FolderOptions: gdown.FolderOptions{DownloadOptions: gdown.DownloadOptions{
+		Quiet: true, APIKey: "test-key", CacheDir: t.TempDir(),
+	}}}
+	if _, err := gdown.SyncFolder("", "root", dir+string(os.PathSeparator), opts); err != nil {
+		t.Fatal(err)
+	}
+	extra := filepath.Join(dir, "root", "extra.txt")
+	if err := os.WriteFile(extra, []byte("extra"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Delete, opts.SkipShortcuts = true, true
+	res, err := gdown.SyncFolder("", "root", dir+string(os.PathSeparator), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Count(gdown.SyncDeleted) != 1 {
+		t.Errorf("sync: %s, want only extra.txt deleted", res.Summary())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "root", "link.txt")); err != nil {
+		t.Errorf("the copy of a skipped shortcut was removed: %v", err)
+	}
+	if _, err := os.Stat(extra); !os.IsNotExist(err) {
+		t.Errorf("extra.txt wasn't deleted: %v", err)
+	}
+}
+
+func TestSyncFolder(t *testing.T) {
+	srv := newServer(t)
+	dir := t.TempDir()
+	opts := gdown.SyncOptions{FolderOptions: gdown.FolderOptions{DownloadOptions: options(t, backends["api"])}, Delete: true}
+	sync := func() *gdown.SyncResult {
+		t.Helper()
+		res, err := gdown.SyncFolder("", "root", dir+string(os.PathSeparator), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if res := sync(); res.Count(gdown.SyncAdded) != 4 {
+		t.Fatalf("first sync: %s, want 4 added", res.Summary())
+	}
+
+	a := srv.AddFile("root", "a", "a.txt", []byte("alpha 2"))
+	a.ModTime = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	srv.Remove("b")
+	res := sync()
+	for action, want := range map[gdown.SyncAction]int{gdown.SyncUpdated: 1, gdown.SyncDeleted: 1} {
+		if n := res.Count(action); n != want {
+			t.Errorf("second sync: %s, want %d %s", res.Summary(), want, action)
+		}
+	}
+	if got := readFile(t, filepath.Join(dir, "root", "a.txt")); got != "alpha 2" {
+		t.Errorf("a.txt holds %q after the update", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "root", "sub", "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("b.txt wasn't deleted: %v", err)
+	}
+}
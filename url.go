@@ -0,0 +1,276 @@
+package gdown
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//
+// URL parsing helpers (from parse_url.py)
+//
+
+// URLKind is the kind of Google Drive item a URL points to.
+type URLKind string
+
+const (
+	KindFile         URLKind = "file"
+	KindFolder       URLKind = "folder"
+	KindDocument     URLKind = "document"
+	KindSpreadsheet  URLKind = "spreadsheet"
+	KindPresentation URLKind = "presentation"
+	KindDrawing      URLKind = "drawing"
+	KindForm         URLKind = "form"
+)
+
+// ParsedURL is the classification of a Google Drive URL.
+type ParsedURL struct {
+	ID             string
+	Kind           URLKind
+	ResourceKey    string // resourcekey query parameter of link-shared items
+	AccountIndex   int    // the N in /u/N/ or authuser=N; -1 if absent
+	IsDownloadLink bool   // direct download link (uc or drive.usercontent.google.com)
+}
+
+// Base URLs of the Google Drive hosts used to build request URLs. They can
+// be pointed at a local server (e.g. httptest) in tests; URLs on the
+// configured hosts are recognized as Google Drive URLs.
+var (
+	DriveBaseURL       = "https://drive.google.com"
+	DocsBaseURL        = "https://docs.google.com"
+	UserContentBaseURL = "https://drive.usercontent.google.com"
+)
+
+// driveHosts are the hosts serving Google Drive items.
+var driveHosts = map[string]bool{
+	"drive.google.com":             true,
+	"docs.google.com":              true,
+	"drive.usercontent.google.com": true,
+}
+
+// isDriveHost reports whether u is served by a Google Drive host, either a
+// public one or one of the configured base URLs.
+func isDriveHost(u *url.URL) bool {
+	if driveHosts[u.Hostname()] {
+		return true
+	}
+	for _, base := range []string{DriveBaseURL, DocsBaseURL, UserContentBaseURL} {
+		if b, err := url.Parse(base); err == nil && b.Host == u.Host {
+			return true
+		}
+	}
+	return false
+}
+
+// isUserContentHost reports whether u is served by the direct download host.
+func isUserContentHost(u *url.URL) bool {
+	if u.Hostname() == "drive.usercontent.google.com" {
+		return true
+	}
+	b, err := url.Parse(UserContentBaseURL)
+	return err == nil && b.Host == u.Host
+}
+
+func IsGoogleDriveUrl(urlStr string) bool {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+	return isDriveHost(u)
+}
+
+// docKinds maps the first path segment of document URLs to their kind.
+var docKinds = map[string]URLKind{
+	"file":         KindFile,
+	"document":     KindDocument,
+	"spreadsheets": KindSpreadsheet,
+	"presentation": KindPresentation,
+	"drawings":     KindDrawing,
+	"forms":        KindForm,
+}
+
+var (
+	// /file/d/ID, /document/u/1/d/ID/edit, /forms/d/e/ID/viewform, ...
+	docPathRe = regexp.MustCompile(`^(?:/u/([0-9]+))?/(file|document|spreadsheets|presentation|drawings|forms)(?:/u/([0-9]+))?/d/(?:e/)?([^/]+)(?:/.*)?$`)
+	// /drive/folders/ID, /drive/u/1/folders/ID, /drive/mobile/folders/ID
+	folderPathRe = regexp.MustCompile(`^/drive(?:/u/([0-9]+))?(?:/mobile)?/folders/([^/]+)(?:/.*)?$`)
+	// /u/1/uc, /u/1/open, ...
+	accountPathRe = regexp.MustCompile(`^/u/([0-9]+)/`)
+)
+
+// ClassifyUrl recognizes the Google Drive URL shapes (file, folder and
+// editor links, open?id=, uc?id=, drive.usercontent.google.com downloads)
+// and returns the item ID, its kind, resource key and account index. It
+// returns nil if urlStr isn't a recognized Google Drive URL.
+func ClassifyUrl(urlStr string) (*ParsedURL, error) {
+	parsed, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	if !isDriveHost(parsed) {
+		return nil, nil
+	}
+	query := parsed.Query()
+	p := &ParsedURL{
+		ResourceKey:  query.Get("resourcekey"),
+		AccountIndex: -1,
+	}
+	if n, err := strconv.Atoi(query.Get("authuser")); err == nil {
+		p.AccountIndex = n
+	}
+	setAccount := func(s string) {
+		if n, err := strconv.Atoi(s); err == nil {
+			p.AccountIndex = n
+		}
+	}
+
+	urlPath := strings.TrimSuffix(parsed.Path, "/")
+	if m := docPathRe.FindStringSubmatch(urlPath); m != nil {
+		setAccount(m[1])
+		setAccount(m[3])
+		p.Kind = docKinds[m[2]]
+		p.ID = m[4]
+		return p, nil
+	}
+	if m := folderPathRe.FindStringSubmatch(urlPath); m != nil {
+		setAccount(m[1])
+		p.Kind = KindFolder
+		p.ID = m[2]
+		return p, nil
+	}
+	if m := accountPathRe.FindStringSubmatch(urlPath + "/"); m != nil {
+		setAccount(m[1])
+		urlPath = strings.TrimPrefix(urlPath, "/u/"+m[1])
+	}
+	if !query.Has("id") {
+		return nil, nil
+	}
+	p.ID = query.Get("id")
+	p.Kind = KindFile
+	switch {
+	case isUserContentHost(parsed):
+		p.IsDownloadLink = true
+	case urlPath == "/uc":
+		p.IsDownloadLink = true
+	case urlPath == "/folderview" || urlPath == "/embeddedfolderview":
+		p.Kind = KindFolder
+	}
+	return p, nil
+}
+
+// ParseUrl extracts a Google Drive file ID (if any) from the URL.
+func ParseUrl(urlStr string, warn bool) (fileId string, isDownloadLink bool, err error) {
+	p, err := ClassifyUrl(urlStr)
+	if err != nil || p == nil {
+		return "", false, err
+	}
+	if warn && p.ID != "" && !p.IsDownloadLink {
+		fmt.Fprintln(os.Stderr, "Warning: You specified a Google Drive link that is not a direct download link. Consider using fuzzy matching.")
+	}
+	return p.ID, p.IsDownloadLink, nil
+}
+
+// resourceKeysHeader carries "ID/KEY" pairs for link-shared items that
+// require a resource key.
+const resourceKeysHeader = "X-Goog-Drive-Resource-Keys"
+
+// withResourceKey adds the resourcekey query parameter to urlStr if key is
+// not empty.
+func withResourceKey(urlStr, key string) string {
+	if key == "" {
+		return urlStr
+	}
+	sep := "?"
+	if strings.Contains(urlStr, "?") {
+		sep = "&"
+	}
+	return urlStr + sep + "resourcekey=" + url.QueryEscape(key)
+}
+
+//
+// Canonical URL builders
+//
+
+// docSegments maps Google-native kinds to their editor path segment.
+var docSegments = map[URLKind]string{
+	KindDocument:     "document",
+	KindSpreadsheet:  "spreadsheets",
+	KindPresentation: "presentation",
+	KindDrawing:      "drawings",
+	KindForm:         "forms",
+}
+
+// defaultExportFormats is the export format used for each kind when none
+// is given.
+var defaultExportFormats = map[URLKind]string{
+	KindDocument:     "docx",
+	KindSpreadsheet:  "xlsx",
+	KindPresentation: "pptx",
+	KindDrawing:      "png",
+}
+
+// FileDownloadURL returns the direct download URL of a file.
+func FileDownloadURL(id, resourceKey string) string {
+	return withResourceKey(DriveBaseURL+"/uc?id="+url.QueryEscape(id), resourceKey)
+}
+
+// FolderURL returns the URL of a folder.
+func FolderURL(id, resourceKey string) string {
+	return withResourceKey(DriveBaseURL+"/drive/folders/"+url.PathEscape(id), resourceKey)
+}
+
+// ExportURL returns the URL exporting a Google Docs, Sheets, Slides or
+// Drawings item in format (e.g. docx, xlsx, pptx, pdf). An empty format
+// selects the default for the kind.
+func ExportURL(id string, kind URLKind, format string) (string, error) {
+	if format == "" {
+		format = defaultExportFormats[kind]
+	}
+	segment, ok := docSegments[kind]
+	if !ok || kind == KindForm {
+		return "", fmt.Errorf("%s items can't be exported", kind)
+	}
+	base := DocsBaseURL + "/" + segment + "/d/" + url.PathEscape(id)
+	if kind == KindDrawing {
+		return base + "/export/" + url.PathEscape(format), nil
+	}
+	return base + "/export?format=" + url.QueryEscape(format), nil
+}
+
+// ViewURL returns the URL opening an item in the browser.
+func ViewURL(id string, kind URLKind) string {
+	switch kind {
+	case KindFolder:
+		return FolderURL(id, "")
+	case KindForm:
+		return DocsBaseURL + "/forms/d/" + url.PathEscape(id) + "/viewform"
+	}
+	if segment, ok := docSegments[kind]; ok {
+		return DocsBaseURL + "/" + segment + "/d/" + url.PathEscape(id) + "/edit"
+	}
+	return DriveBaseURL + "/file/d/" + url.PathEscape(id) + "/view"
+}
+
+// NormalizeURL converts any recognized Google Drive link to its canonical
+// form: the direct download URL for files, the folder URL for folders and
+// the editor URL for Google-native items. Resource keys are preserved.
+func NormalizeURL(urlStr string) (string, error) {
+	p, err := ClassifyUrl(urlStr)
+	if err != nil {
+		return "", err
+	}
+	if p == nil || p.ID == "" {
+		return "", fmt.Errorf("not a recognized Google Drive URL: %s", urlStr)
+	}
+	switch p.Kind {
+	case KindFile:
+		return FileDownloadURL(p.ID, p.ResourceKey), nil
+	case KindFolder:
+		return FolderURL(p.ID, p.ResourceKey), nil
+	default:
+		return withResourceKey(ViewURL(p.ID, p.Kind), p.ResourceKey), nil
+	}
+}
@@ -0,0 +1,72 @@
+package gdown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassifyUrl(t *testing.T) {
+	for _, tt := range []struct {
+		url  string
+		want *ParsedURL
+	}{
+		{"https://drive.google.com/open?id=ID", &ParsedURL{ID: "ID", Kind: KindFile, AccountIndex: -1}},
+		{"https://drive.google.com/uc?id=ID&export=download", &ParsedURL{ID: "ID", Kind: KindFile, AccountIndex: -1, IsDownloadLink: true}},
+		{"https://drive.google.com/u/2/uc?id=ID", &ParsedURL{ID: "ID", Kind: KindFile, AccountIndex: 2, IsDownloadLink: true}},
+		{"https://drive.usercontent.google.com/download?id=ID&export=download", &ParsedURL{ID: "ID", Kind: KindFile, AccountIndex: -1, IsDownloadLink: true}},
+		{"https://drive.google.com/drive/folders/ID", &ParsedURL{ID: "ID", Kind: KindFolder, AccountIndex: -1}},
+		{"https://drive.google.com/drive/u/1/folders/ID?usp=sharing", &ParsedURL{ID: "ID", Kind: KindFolder, AccountIndex: 1}},
+		{"https://drive.google.com/drive/mobile/folders/ID", &ParsedURL{ID: "ID", Kind: KindFolder, AccountIndex: -1}},
+		{"https://drive.google.com/embeddedfolderview?id=ID", &ParsedURL{ID: "ID", Kind: KindFolder, AccountIndex: -1}},
+		{"https://drive.google.com/file/d/ID", &ParsedURL{ID: "ID", Kind: KindFile, AccountIndex: -1}},
+		{"https://drive.google.com/file/d/ID/view?usp=sharing", &ParsedURL{ID: "ID", Kind: KindFile, AccountIndex: -1}},
+		{"https://drive.google.com/file/d/ID/preview", &ParsedURL{ID: "ID", Kind: KindFile, AccountIndex: -1}},
+		{"https://drive.google.com/file/u/3/d/ID/view", &ParsedURL{ID: "ID", Kind: KindFile, AccountIndex: 3}},
+		{"https://docs.google.com/document/d/ID/edit", &ParsedURL{ID: "ID", Kind: KindDocument, AccountIndex: -1}},
+		{"https://docs.google.com/spreadsheets/d/ID/edit#gid=0", &ParsedURL{ID: "ID", Kind: KindSpreadsheet, AccountIndex: -1}},
+		{"https://docs.google.com/presentation/u/1/d/ID/edit", &ParsedURL{ID: "ID", Kind: KindPresentation, AccountIndex: 1}},
+		{"https://docs.google.com/drawings/d/ID/edit", &ParsedURL{ID: "ID", Kind: KindDrawing, AccountIndex: -1}},
+		{"https://docs.google.com/forms/d/e/ID/viewform", &ParsedURL{ID: "ID", Kind: KindForm, AccountIndex: -1}},
+		{"https://drive.google.com/file/d/ID/view?resourcekey=KEY", &ParsedURL{ID: "ID", Kind: KindFile, ResourceKey: "KEY", AccountIndex: -1}},
+		{"https://drive.google.com/drive/folders/ID?resourcekey=KEY&authuser=4", &ParsedURL{ID: "ID", Kind: KindFolder, ResourceKey: "KEY", AccountIndex: 4}},
+		{"https://drive.google.com/drive/my-drive", nil},
+		{"https://example.com/file/d/ID/view", nil},
+		{"https://example.com/uc?id=ID", nil},
+	} {
+		got, err := ClassifyUrl(tt.url)
+		if err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %+v, want %+v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	for _, tt := range []struct {
+		url, want string
+	}{
+		{"https://drive.google.com/open?id=ID", "https://drive.google.com/uc?id=ID"},
+		{"https://drive.google.com/file/d/ID/view?resourcekey=KEY", "https://drive.google.com/uc?id=ID&resourcekey=KEY"},
+		{"https://drive.usercontent.google.com/download?id=ID", "https://drive.google.com/uc?id=ID"},
+		{"https://drive.google.com/drive/u/1/folders/ID", "https://drive.google.com/drive/folders/ID"},
+		{"https://docs.google.com/document/d/ID/export?format=pdf", "https://docs.google.com/document/d/ID/edit"},
+		{"https://docs.google.com/forms/d/e/ID/viewform", "https://docs.google.com/forms/d/ID/viewform"},
+	} {
+		got, err := NormalizeURL(tt.url)
+		if err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeURL(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+	for _, u := range []string{"https://example.com/file/d/ID/view", "https://drive.google.com/drive/my-drive"} {
+		if got, err := NormalizeURL(u); err == nil {
+			t.Errorf("NormalizeURL(%s) = %s, want an error", u, got)
+		}
+	}
+}